@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"envoy-swarm-control/pkg/audit"
+	"envoy-swarm-control/pkg/config"
+	"envoy-swarm-control/pkg/snapshot"
+)
+
+/* Function runAudit:
+ * queries the audit log: every configuration change with what it changed
+ * and whether the node accepted it, or raw entries with --json.
+ */
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	configPath := fs.String("config", "", "Control plane configuration file, to locate the audit log")
+	file := fs.String("file", "", "Audit log, defaults to the one of the configuration")
+	service := fs.String("service", "", "Only entries of this service")
+	node := fs.String("node", "", "Only entries of this node")
+	kind := fs.String("kind", "", "Comma-separated kinds of entries to print with --json, e.g., config-change,node-identity")
+	since := fs.String("since", "", "Only entries after this time (RFC 3339) or within this duration, e.g., 2h")
+	asJSON := fs.Bool("json", false, "Print the matching entries as JSON lines")
+	fs.Parse(args)
+
+	c := config.Default()
+	if *configPath != "" {
+		var err error
+		if c, err = config.Load(*configPath); err != nil {
+			return err
+		}
+	}
+	path := *file
+	if path == "" {
+		path = c.AuditLogPath()
+	}
+	if path == "" {
+		return fmt.Errorf("no audit log, use --file")
+	}
+
+	filter := audit.Filter{Node: *node, Service: *service}
+	if *since != "" {
+		t, err := parseSince(*since)
+		if err != nil {
+			return err
+		}
+		filter.Since = t
+	}
+	if *asJSON {
+		filter.Kinds = splitList(*kind)
+	} else {
+		filter.Kinds = []string{snapshot.AuditConfigChange, snapshot.AuditConfigAck}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entries, err := audit.Read(f, filter)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return printChanges(os.Stdout, entries)
+}
+
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("--since %q is neither a duration nor an RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+/* Function printChanges:
+ * prints one block per configuration change, with the outcome of the
+ * versions it served as reported by the node's first ACK or NACK.
+ */
+func printChanges(w io.Writer, entries []audit.RawEntry) error {
+	type ackKey struct{ node, typeURL, version string }
+	outcomes := make(map[ackKey]snapshot.AckRecord)
+	for _, e := range entries {
+		if e.Kind != snapshot.AuditConfigAck {
+			continue
+		}
+		var ack snapshot.AckRecord
+		if err := json.Unmarshal(e.Detail, &ack); err != nil {
+			return fmt.Errorf("entry of %s: %w", e.Time.Format(time.RFC3339), err)
+		}
+		key := ackKey{e.Node, ack.TypeURL, ack.Version}
+		if _, seen := outcomes[key]; !seen {
+			outcomes[key] = ack
+		}
+	}
+
+	changes := 0
+	for _, e := range entries {
+		if e.Kind != snapshot.AuditConfigChange {
+			continue
+		}
+		var change snapshot.ChangeRecord
+		if err := json.Unmarshal(e.Detail, &change); err != nil {
+			return fmt.Errorf("entry of %s: %w", e.Time.Format(time.RFC3339), err)
+		}
+		changes++
+
+		fmt.Fprintf(w, "%s %s node %s", e.Time.Format(time.RFC3339), change.Trigger, e.Node)
+		if e.Service != "" {
+			fmt.Fprintf(w, " service %s", e.Service)
+		}
+		if change.Event != "" {
+			fmt.Fprintf(w, " on %s", change.Event)
+		}
+		fmt.Fprintln(w)
+
+		types := make([]string, 0, len(change.NewVersions))
+		for typ := range change.NewVersions {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+		for _, typ := range types {
+			short := typ[strings.LastIndex(typ, ".")+1:]
+			res := change.Resources[typ]
+			for _, name := range res.Added {
+				fmt.Fprintf(w, "  + %s %s\n", short, name)
+			}
+			for _, name := range res.Removed {
+				fmt.Fprintf(w, "  - %s %s\n", short, name)
+			}
+			for _, name := range res.Modified {
+				fmt.Fprintf(w, "  ~ %s %s\n", short, name)
+			}
+
+			version := change.NewVersions[typ]
+			if old := change.OldVersions[typ]; old == version {
+				continue
+			}
+			outcome := "pending"
+			if ack, ok := outcomes[ackKey{e.Node, typ, version}]; ok && ack.Acked {
+				outcome = "ACKed"
+			} else if ok {
+				outcome = "NACKed: " + ack.Error
+			}
+			fmt.Fprintf(w, "    %s %s -> %s %s\n", short, orNone(change.OldVersions[typ]), version, outcome)
+		}
+	}
+	if changes == 0 {
+		fmt.Fprintln(w, "No configuration changes")
+	}
+	return nil
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
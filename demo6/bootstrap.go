@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+
+	"envoy-swarm-control/pkg/bootstrap"
+	"envoy-swarm-control/pkg/config"
+)
+
+/* Function runBootstrap:
+ * prints the bootstrap of a mesh node, consistent with the control plane
+ * configuration: xDS cluster name, port, keepalives, TLS and delta mode.
+ */
+func runBootstrap(args []string) error {
+	fs := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	configPath := fs.String("config", "", "Control plane configuration file")
+	nodeID := fs.String("node-id", "", "Envoy node ID, as in the envoy.status.node-id label")
+	cluster := fs.String("cluster", "", "Envoy node cluster, defaults to the node ID")
+	adminPort := fs.Uint("admin-port", 9901, "Envoy admin port")
+	xdsAddress := fs.String("xds-address", "host.docker.internal", "Host, or host:port, of the control plane as seen from Envoy")
+	certDir := fs.String("cert-dir", "/etc/envoy/certs", "Directory of ca.crt and <node ID>.crt/.key inside the Envoy container, used when the control plane serves TLS")
+	maxConnections := fs.Uint64("max-connections", 100, "Runtime limit of downstream connections, 0 for none")
+	maxHeap := fs.Uint64("max-heap-bytes", 0, "Heap size at which the overload manager sheds load, 0 to disable it")
+	format := fs.String("format", "yaml", "Output format: yaml or json")
+	out := fs.String("out", "", "Output file, stdout when empty")
+	fs.Parse(args)
+
+	c := config.Default()
+	if *configPath != "" {
+		var err error
+		if c, err = config.Load(*configPath); err != nil {
+			return err
+		}
+	}
+	if *nodeID == "" {
+		return errors.New("--node-id is required")
+	}
+	if *cluster == "" {
+		*cluster = *nodeID
+	}
+
+	host, port := *xdsAddress, strconv.FormatUint(uint64(c.XDSPort), 10)
+	if h, p, err := net.SplitHostPort(*xdsAddress); err == nil {
+		host, port = h, p
+	}
+	xdsPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid xDS port %q", port)
+	}
+
+	opts := bootstrap.Options{
+		NodeID:            *nodeID,
+		Cluster:           *cluster,
+		AdminPort:         uint32(*adminPort),
+		XDSHost:           host,
+		XDSPort:           uint32(xdsPort),
+		XDSClusterName:    c.XDSClusterName,
+		Delta:             c.Delta,
+		KeepaliveInterval: c.GRPC.KeepaliveMinTime, // pinging more often gets the connection closed
+		KeepaliveTimeout:  c.GRPC.KeepaliveTimeout,
+		RuntimeLayer:      c.Runtime.LayerName,
+		StatsSink:         c.Stats.Sink,
+		StatsFlush:        c.Stats.FlushInterval,
+		MaxConnections:    *maxConnections,
+		MaxHeapBytes:      *maxHeap,
+	}
+	if c.TLS.CertFile != "" {
+		opts.TLS = &bootstrap.TLS{
+			CAFile:   path.Join(*certDir, "ca.crt"),
+			CertFile: path.Join(*certDir, *nodeID+".crt"),
+			KeyFile:  path.Join(*certDir, *nodeID+".key"),
+			SNI:      host,
+		}
+	}
+
+	b, err := bootstrap.Build(opts)
+	if err != nil {
+		return err
+	}
+	data, err := bootstrap.Render(b, *format)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*out, data, 0o644)
+}
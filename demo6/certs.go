@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"envoy-swarm-control/pkg/pki"
+
+	"github.com/sirupsen/logrus"
+
+	"envoy-sds/cert" // demo7/variant-1/cert
+)
+
+/* Function runCerts:
+ * issues the xDS server certificate and one client certificate per Envoy
+ * node, all signed by the CA kept in the output directory.
+ */
+func runCerts(args []string) error {
+	fs := flag.NewFlagSet("certs", flag.ExitOnError)
+	out := fs.String("out", "deploy/certs/xds", "Directory holding the CA and the issued certificates")
+	serverNames := fs.String("server-names", "host.docker.internal,localhost", "Comma-separated DNS names of the control plane")
+	nodes := fs.String("nodes", "", "Comma-separated Envoy nodes to issue client certificates for, as <node ID> or <cluster>/<node ID> to bind the cluster as well")
+	trustDomain := fs.String("trust-domain", "envoy-swarm-control", "Trust domain of the SPIFFE IDs binding node clusters")
+	validity := fs.Duration("validity", cert.CertValidityYear, "Validity of the issued certificates")
+	fs.Parse(args)
+
+	ca, err := pki.LoadOrCreateAuthority(*out)
+	if err != nil {
+		return fmt.Errorf("loading CA: %w", err)
+	}
+
+	if *serverNames != "" {
+		if err := ca.IssueServer("control-plane", splitList(*serverNames), *validity); err != nil {
+			return fmt.Errorf("issuing server certificate: %w", err)
+		}
+		logrus.Infof("Issued %s", filepath.Join(*out, "control-plane.crt"))
+	}
+	for _, item := range splitList(*nodes) {
+		var spiffeIDs []*url.URL
+		node := item
+		if cluster, id, ok := strings.Cut(item, "/"); ok {
+			node = id
+			spiffeIDs = append(spiffeIDs, &url.URL{Scheme: "spiffe", Host: *trustDomain, Path: "/" + cluster + "/" + node})
+		}
+		if err := ca.IssueClient(node, []string{node}, spiffeIDs, *validity); err != nil {
+			return fmt.Errorf("issuing certificate of node %s: %w", node, err)
+		}
+		logrus.Infof("Issued %s", filepath.Join(*out, node+".crt"))
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -10,6 +10,15 @@ DOCKER_NETWORK_ID=$(docker network create --driver=overlay --attachable $DOCKER_
 ENVOY_SERVICE_1_NAME="envoy-1" && ENVOY_SERVICE_2_NAME="envoy-2"
 ENVOY_IMAGE_1_NAME="envoy-1:v1" && ENVOY_IMAGE_2_NAME="envoy-2:v1"
 
+# The Envoy bootstraps are generated from the control plane configuration
+(cd $DEMO_BASEDIR && \
+    go run envoy-swarm-control bootstrap --config deploy/control-plane/config.yaml \
+        --node-id local_node_1 --cluster local_cluster_1 --admin-port 9001 \
+        --out deploy/envoy/envoy-1/config.yaml && \
+    go run envoy-swarm-control bootstrap --config deploy/control-plane/config.yaml \
+        --node-id local_node_2 --cluster local_cluster_2 --admin-port 9002 \
+        --out deploy/envoy/envoy-2/config.yaml) || exit 1
+
 # For each Envoy service, we might want three sets of port publishing rules:
 # 1. administration server port
 # 2. static listener port
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"envoy-swarm-control/pkg/admin"
+	"envoy-swarm-control/pkg/config"
+	"envoy-swarm-control/pkg/snapshot"
+	"envoy-swarm-control/pkg/xdstypes"
+
+	"github.com/docker/docker/api/types"
+	swarm "github.com/docker/docker/api/types/swarm"
+	"github.com/sirupsen/logrus"
+)
+
+const adminRequestTimeout = 10 * time.Second
+
+/* Function runDiff:
+ * previews a label change: prints the resources the labels translate to
+ * and how they differ from what the running control plane serves the node.
+ * Nothing is published.
+ */
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	configPath := fs.String("config", "", "Control plane configuration file, for the same HTTP settings as the running control plane")
+	labelsPath := fs.String("labels", "", "Labels as a JSON object or key=value lines, - for stdin")
+	specPath := fs.String("spec", "", "Service spec as printed by docker service inspect --format '{{json .Spec}}'")
+	serviceName := fs.String("service", "", "Name of a live swarm service")
+	adminURL := fs.String("admin", fmt.Sprintf("http://localhost:%d", config.Default().AdminPort), "Admin API of the running control plane, empty to only print the resources")
+	quiet := fs.Bool("quiet", false, "Only print the diff")
+	fs.Parse(args)
+	logrus.SetLevel(logrus.WarnLevel) // keep the builders' progress out of the output
+
+	conf = config.Default()
+	if *configPath != "" {
+		var err error
+		if conf, err = config.Load(*configPath); err != nil {
+			return err
+		}
+	}
+	applyConfig()
+
+	name, labels, err := diffInput(*labelsPath, *specPath, *serviceName)
+	if err != nil {
+		return err
+	}
+
+	update := snapshot.ParseServiceLabels(labels)
+	if err := update.Validate(); err != nil {
+		return fmt.Errorf("invalid labels: %w", err)
+	}
+	update.ServiceName = name
+
+	var runtimeValues *snapshot.RuntimeFile
+	if conf.Runtime.File != "" {
+		if runtimeValues, err = snapshot.LoadRuntimeFile(conf.Runtime.File); err != nil {
+			return err
+		}
+	}
+	snap, err := snapshot.BuildSnapshot(*update, runtimeValues)
+	if err != nil {
+		return err
+	}
+	if err := snapshot.ValidateSnapshot(name, snap); err != nil {
+		return err
+	}
+	if err := snap.Consistent(); err != nil {
+		return fmt.Errorf("snapshot inconsistency: %w", err)
+	}
+
+	desired, err := admin.RenderSnapshot(snap)
+	if err != nil {
+		return err
+	}
+	nodeID := update.Status.NodeID
+	if !*quiet {
+		fmt.Printf("# Resources generated for node %s\n", nodeID)
+		if err := printJSON(desired); err != nil {
+			return err
+		}
+	}
+
+	if *adminURL == "" {
+		return nil
+	}
+	current, err := fetchServedSnapshot(*adminURL, nodeID)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		fmt.Printf("# Node %s is not served yet, every resource is new\n", nodeID)
+		current = map[string]admin.TypeSnapshot{}
+	} else {
+		fmt.Printf("# Changes to what node %s is served\n", nodeID)
+	}
+	printSnapshotDiff(os.Stdout, current, desired)
+	return nil
+}
+
+/* Function diffInput:
+ * returns the service name and labels from exactly one of the sources.
+ */
+func diffInput(labelsPath, specPath, serviceName string) (string, map[string]string, error) {
+	set := 0
+	for _, s := range []string{labelsPath, specPath, serviceName} {
+		if s != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return "", nil, errors.New("exactly one of --labels, --spec and --service is required")
+	}
+
+	switch {
+	case labelsPath != "":
+		data, err := readInput(labelsPath)
+		if err != nil {
+			return "", nil, err
+		}
+		labels, err := parseLabels(data)
+		return "", labels, err
+
+	case specPath != "":
+		data, err := readInput(specPath)
+		if err != nil {
+			return "", nil, err
+		}
+		var spec swarm.ServiceSpec
+		if err := json.Unmarshal(data, &spec); err != nil {
+			return "", nil, fmt.Errorf("parsing service spec: %w", err)
+		}
+		return spec.Name, spec.Labels, nil
+
+	default:
+		cli := newDockerClient()
+		defer cli.Close()
+		service, _, err := cli.ServiceInspectWithRaw(context.Background(), serviceName, types.ServiceInspectOptions{})
+		if err != nil {
+			return "", nil, err
+		}
+		return service.Spec.Name, service.Spec.Labels, nil
+	}
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+/* Function parseLabels:
+ * accepts a JSON object, or key=value lines as given to docker --label.
+ */
+func parseLabels(data []byte) (map[string]string, error) {
+	labels := make(map[string]string)
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		if err := json.Unmarshal(data, &labels); err != nil {
+			return nil, fmt.Errorf("parsing labels: %w", err)
+		}
+		return labels, nil
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key=value", i+1)
+		}
+		labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+/* Function fetchServedSnapshot:
+ * returns what the running control plane serves a node, nil if nothing.
+ */
+func fetchServedSnapshot(adminURL, nodeID string) (map[string]admin.TypeSnapshot, error) {
+	client := &http.Client{Timeout: adminRequestTimeout}
+	resp, err := client.Get(strings.TrimSuffix(adminURL, "/") + "/snapshots/" + url.PathEscape(nodeID))
+	if err != nil {
+		return nil, fmt.Errorf("querying the control plane: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("querying the control plane: %s", resp.Status)
+	}
+
+	var out map[string]admin.TypeSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decoding served snapshot: %w", err)
+	}
+	return out, nil
+}
+
+/* Function printSnapshotDiff:
+ * prints added (+), removed (-) and modified (~) resources, and for the
+ * latter every field that changed. Secrets are redacted on both sides and
+ * thus only compared by name.
+ */
+func printSnapshotDiff(w io.Writer, current, desired map[string]admin.TypeSnapshot) {
+	changes := 0
+	for _, typ := range xdstypes.ResourceTypes {
+		short := typ[strings.LastIndex(typ, ".")+1:]
+		names := make(map[string]bool)
+		for name := range current[typ].Resources {
+			names[name] = true
+		}
+		for name := range desired[typ].Resources {
+			names[name] = true
+		}
+		sorted := make([]string, 0, len(names))
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+
+		for _, name := range sorted {
+			before, hadBefore := current[typ].Resources[name]
+			after, hasAfter := desired[typ].Resources[name]
+			switch {
+			case !hadBefore:
+				fmt.Fprintf(w, "+ %s %s\n", short, name)
+			case !hasAfter:
+				fmt.Fprintf(w, "- %s %s\n", short, name)
+			default:
+				fields := diffJSON(before, after)
+				if len(fields) == 0 {
+					continue
+				}
+				fmt.Fprintf(w, "~ %s %s\n", short, name)
+				for _, f := range fields {
+					fmt.Fprintf(w, "    %s\n", f)
+				}
+			}
+			changes++
+		}
+	}
+	if changes == 0 {
+		fmt.Fprintln(w, "No changes")
+	}
+}
+
+func diffJSON(before, after json.RawMessage) []string {
+	var a, b interface{}
+	if json.Unmarshal(before, &a) != nil || json.Unmarshal(after, &b) != nil {
+		return []string{"(unreadable resource)"}
+	}
+	var out []string
+	diffValues("", a, b, &out)
+	return out
+}
+
+/* Function diffValues:
+ * walks two decoded JSON documents and reports every path whose value
+ * differs, e.g., "address.socketAddress.portValue: 80 -> 8080".
+ */
+func diffValues(path string, a, b interface{}, out *[]string) {
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range av {
+			keys[k] = true
+		}
+		for k := range bv {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			diffValues(joinPath(path, k), av[k], bv[k], out)
+		}
+		return
+
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			var x, y interface{}
+			if i < len(av) {
+				x = av[i]
+			}
+			if i < len(bv) {
+				y = bv[i]
+			}
+			diffValues(fmt.Sprintf("%s[%d]", path, i), x, y, out)
+		}
+		return
+	}
+
+	*out = append(*out, fmt.Sprintf("%s: %s -> %s", path, compactJSON(a), compactJSON(b)))
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func compactJSON(v interface{}) string {
+	if v == nil {
+		return "(none)"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -7,22 +7,40 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"envoy-swarm-control/pkg/accesslog"
+	"envoy-swarm-control/pkg/admin"
+	"envoy-swarm-control/pkg/audit"
 	"envoy-swarm-control/pkg/callback"
+	"envoy-swarm-control/pkg/config"
+	"envoy-swarm-control/pkg/configresource"
+	"envoy-swarm-control/pkg/election"
+	"envoy-swarm-control/pkg/metrics"
+	"envoy-swarm-control/pkg/pki"
 	"envoy-swarm-control/pkg/snapshot"
+	"envoy-swarm-control/pkg/store"
 	"envoy-swarm-control/pkg/watcher"
+	"envoy-swarm-control/pkg/xdscache"
 
 	docker "github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
 
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	accesslogservice "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
 	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
 	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	extensionservice "github.com/envoyproxy/go-control-plane/envoy/service/extension/v3"
 	listenerservice "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
+	metricsservice "github.com/envoyproxy/go-control-plane/envoy/service/metrics/v3"
 	routeservice "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
 	runtimeservice "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
 	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
@@ -30,30 +48,111 @@ import (
 )
 
 var (
+	configFile     string
 	debug          bool
 	xdsPort        uint
+	adminPort      uint
+	adminAddress   string
 	ingressNetwork string
+	stateDir       string
+	leaseFile      string
+	leaseID        string
+	deltaXDS       bool
+	tlsCert        string
+	tlsKey         string
+	tlsClientCA    string
+	auditLog       string
+	runtimeFile    string
+	accessLogSink  string
+	tracingURL     string
+
+	conf *config.Config // configuration file with the flags above applied
 )
 
 const (
-	grpcKeepaliveTime        = 30 * time.Second
-	grpcKeepaliveTimeout     = 5 * time.Second
-	grpcKeepaliveMinTime     = 30 * time.Second
-	grpcMaxConcurrentStreams = 1000000
+	standbySyncInterval = 5 * time.Second
+	runtimeFileInterval = 5 * time.Second
 )
 
+// Subcommands of the binary, the control plane runs when none is given
+var commands = map[string]func(args []string) error{
+	"audit":     runAudit,
+	"bootstrap": runBootstrap,
+	"certs":     runCerts,
+	"diff":      runDiff,
+}
+
 func init() {
+	defaults := config.Default()
+	flag.StringVar(&configFile, "config", "", "YAML configuration file, flags set on the command line override it")
 	flag.BoolVar(&debug, "debug", true, "Enable xDS server debug logging")
-	flag.UintVar(&xdsPort, "xds-port", 18000, "xDS management server port")                              // Port number to which Envoy instances are bound for configuration updates
-	flag.StringVar(&ingressNetwork, "ingress-network", "mesh-traffic", "Docker overlay network name/ID") // Deploy using: docker network create --driver=overlay --attachable mesh-traffic
+	flag.UintVar(&xdsPort, "xds-port", defaults.XDSPort, "xDS management server port") // Port number to which Envoy instances are bound for configuration updates
+	flag.UintVar(&adminPort, "admin-port", defaults.AdminPort, "Control plane admin HTTP API port")
+	flag.StringVar(&adminAddress, "admin-address", defaults.AdminAddress, "Address the admin HTTP API listens on, empty for every interface")
+	flag.StringVar(&ingressNetwork, "ingress-network", defaults.IngressNetwork, "Docker overlay network name/ID") // Deploy using: docker network create --driver=overlay --attachable mesh-traffic
+	flag.StringVar(&stateDir, "state-dir", defaults.StateDir, "Directory persisting services and snapshots across restarts, empty to disable")
+	flag.StringVar(&leaseFile, "lease-file", defaults.Lease.File, "Lease file shared by active/standby replicas, empty to run a single replica")
+	flag.StringVar(&leaseID, "lease-id", defaultLeaseID(), "Identity of this replica in the lease file")
+	flag.BoolVar(&deltaXDS, "delta", defaults.Delta, "Keep per-resource versions and push only changed resources over Delta xDS")
+	flag.StringVar(&tlsCert, "tls-cert", defaults.TLS.CertFile, "xDS server certificate, empty to serve plaintext")
+	flag.StringVar(&tlsKey, "tls-key", defaults.TLS.KeyFile, "xDS server private key")
+	flag.StringVar(&tlsClientCA, "tls-client-ca", defaults.TLS.ClientCAFile, "CA Envoy client certificates must be issued by, empty to not require them")
+	flag.StringVar(&auditLog, "audit-log", defaults.AuditLog, "Audit log of configuration changes, ACKs and node identity decisions, defaults to <state-dir>/audit.log")
+	flag.StringVar(&runtimeFile, "runtime-file", defaults.Runtime.File, "YAML file of runtime values served over RTDS, reloaded when it changes")
+	flag.StringVar(&tracingURL, "tracing", "", "Tracing of the generated listeners as <provider>://<collector host>[:port], e.g., zipkin://zipkin:9411, empty for none")
+	flag.StringVar(&accessLogSink, "access-log", defaults.AccessLog.Sink, "Access log of the generated listeners: stdout, file, grpc (the access log service of the control plane), empty for none")
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				logrus.Fatalf("%s: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
 	flag.Parse()
-	mainctx := context.Background()
+	var err error
+	if conf, err = loadConfig(); err != nil {
+		logrus.Fatalf("Invalid configuration: %v", err)
+	}
+	applyConfig()
+
+	mainctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	logrus.Infof("Starting control plane")
 
 	// Create xDS management server
+	config := newSnapshotCache()
+	var st store.Store
+	if conf.StateDir != "" {
+		fileStore, err := store.NewFileStore(conf.StateDir)
+		if err != nil {
+			logrus.Fatalf("Opening state directory: %v", err)
+		}
+		st = fileStore
+	}
+	var auditTrail *audit.Log
+	if path := conf.AuditLogPath(); path != "" {
+		if auditTrail, err = audit.Open(path); err != nil {
+			logrus.Fatalf("Opening audit log: %v", err)
+		}
+		defer auditTrail.Close()
+	}
+	manager := snapshot.NewManager(config, st, auditTrail)
+	if conf.Runtime.File != "" {
+		runtimeValues, err := snapshot.LoadRuntimeFile(conf.Runtime.File)
+		if err != nil {
+			logrus.Fatalf("Loading runtime file: %v", err)
+		}
+		manager.SetRuntimeFile(runtimeValues)
+	}
+	if err := manager.Restore(mainctx); err != nil {
+		logrus.Errorf("Restoring persisted state: %v", err)
+	}
+
 	signal := make(chan struct{})
 	cb := &callback.Callbacks{
 		Signal:         signal,
@@ -61,37 +160,214 @@ func main() {
 		Requests:       0,
 		DeltaRequests:  0,
 		DeltaResponses: 0,
+		Handler:        manager, // roll back to the last ACKed snapshot on NACK
+		// With client certificates, a node may only ask for the configuration of its own ID
+		RequireNodeIdentity: conf.TLS.ClientCAFile != "",
+		Audit:               auditTrail,
+	}
+	srv := server.NewServer(mainctx, config, cb)
+
+	// Entries of the Envoys logging to the grpc sink
+	var accessLogFile *accesslog.RotatingFile
+	if alsConf := conf.AccessLog.Service; alsConf.File != "" {
+		if accessLogFile, err = accesslog.NewRotatingFile(alsConf.File, alsConf.MaxFileSize, alsConf.MaxFiles); err != nil {
+			logrus.Fatalf("Opening access log file: %v", err)
+		}
+		defer accessLogFile.Close()
+	}
+	accessLogs := accesslog.NewServer(conf.AccessLog.Service.Buffer, accessLogFile)
+	accessLogs.Authorize = cb.AuthorizeNode // nodes are bound to their certificate as on xDS streams
+
+	// Stats of the Envoys with a metrics service stats sink, re-exposed on /metrics
+	envoyStats := metrics.NewEnvoyStats(manager.ServiceOfNode)
+	envoyStats.Authorize = cb.AuthorizeNode
+	prometheus.MustRegister(envoyStats)
+
+	// Every goroutine below returns once mainctx is cancelled
+	var wg sync.WaitGroup
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+
+	// Only the leader acts on swarm events and writes snapshots
+	elector := newElector()
+	manager.SetElector(elector)
+	run(func() {
+		elector.Run(mainctx, election.Callbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				reloaded := make(chan struct{})
+				go func() {
+					defer close(reloaded)
+					manager.WatchRuntimeFile(ctx, conf.Runtime.File, runtimeFileInterval)
+				}()
+				update := generateWatcher(ctx)
+				manager.Discover(update, ctx)
+				<-reloaded
+				// A replica that lost the lease must not overwrite what the new leader persists
+				if mainctx.Err() != nil {
+					manager.Flush()
+				}
+			},
+			OnStoppedLeading: func() {
+				if mainctx.Err() == nil {
+					logrus.Infof("Standing by, serving the state persisted by the leader")
+				}
+			},
+		})
+	})
+	run(func() { manager.Follow(mainctx, elector, standbySyncInterval) })
+
+	// Run admin API
+	adminServer := admin.NewServer(cb, manager, config)
+	adminServer.Handle("/metrics", metrics.Handler())
+	adminServer.Handle("/accesslogs", accessLogs.Handler())
+	adminServer.Handle("/accesslogs/", accessLogs.Handler())
+	run(func() { adminServer.Run(mainctx, conf.AdminAddress, conf.AdminPort) })
+
+	// Run xDS management server
+	run(func() {
+		runManagementServer(mainctx, srv, accessLogs, envoyStats, conf.XDSPort, func() { adminServer.SetReady(true) })
+	})
+
+	waitForSignal()
+	adminServer.SetReady(false)
+	cancel()
+	wg.Wait()
+	logrus.Infof("Control plane stopped")
+}
+
+/* Function loadConfig:
+ * reads the configuration file, if any, and overrides it with the flags
+ * explicitly set on the command line.
+ */
+func loadConfig() (*config.Config, error) {
+	c := config.Default()
+	if configFile != "" {
+		var err error
+		if c, err = config.Load(configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "xds-port":
+			c.XDSPort = xdsPort
+		case "admin-port":
+			c.AdminPort = adminPort
+		case "admin-address":
+			c.AdminAddress = adminAddress
+		case "ingress-network":
+			c.IngressNetwork = ingressNetwork
+		case "state-dir":
+			c.StateDir = stateDir
+		case "lease-file":
+			c.Lease.File = leaseFile
+		case "lease-id":
+			c.Lease.ID = leaseID
+		case "delta":
+			c.Delta = deltaXDS
+		case "tls-cert":
+			c.TLS.CertFile = tlsCert
+		case "tls-key":
+			c.TLS.KeyFile = tlsKey
+		case "tls-client-ca":
+			c.TLS.ClientCAFile = tlsClientCA
+		case "audit-log":
+			c.AuditLog = auditLog
+		case "runtime-file":
+			c.Runtime.File = runtimeFile
+		case "access-log":
+			c.AccessLog.Sink = accessLogSink
+		case "tracing":
+			c.Tracing.Provider, c.Tracing.Collector, _ = strings.Cut(tracingURL, "://")
+		}
+	})
+	if c.Lease.ID == "" {
+		c.Lease.ID = leaseID
+	}
+
+	return c, c.Validate()
+}
+
+/* Function applyConfig:
+ * hands the settings of the generated resources over to configresource.
+ */
+func applyConfig() {
+	configresource.CertPath = conf.CertPath
+	configresource.XDSClusterName = conf.XDSClusterName
+	configresource.RuntimeLayerName = conf.Runtime.LayerName
+	configresource.DefaultAccessLog = configresource.AccessLog{
+		Sink:   conf.AccessLog.Sink,
+		Path:   conf.AccessLog.Path,
+		Format: conf.AccessLog.Format,
+	}
+	samplingPercent := conf.Tracing.SamplingPercent
+	configresource.DefaultTracing = configresource.Tracing{
+		Provider:        conf.Tracing.Provider,
+		SamplingPercent: &samplingPercent,
+		Tags:            conf.Tracing.Tags,
+	}
+	configresource.DefaultTracing.CollectorHost, configresource.DefaultTracing.CollectorPort = snapshot.ParseHostPort(conf.Tracing.Collector)
+	configresource.HTTPIdleTimeout = conf.HTTP.IdleTimeout
+	configresource.RequestTimeout = conf.HTTP.RequestTimeout
+	configresource.MaxConcurrentHTTP2Streams = conf.HTTP.MaxConcurrentStreams
+	configresource.InitialDownstreamHTTP2StreamWindowSize = conf.HTTP.InitialStreamWindowSize
+	configresource.InitialDownstreamHTTP2ConnectionWindowSize = conf.HTTP.InitialConnectionWindowSize
+	if conf.Delta {
+		configresource.XDSAPIType = core.ApiConfigSource_DELTA_GRPC
 	}
-	config := cache.NewSnapshotCache(
+}
+
+func newSnapshotCache() cache.SnapshotCache {
+	if conf.Delta {
+		return xdscache.NewLinearSnapshotCache()
+	}
+	return cache.NewSnapshotCache(
 		true, // enable the ADS flag
 		cache.IDHash{},
 		nil,
 	)
-	srv := server.NewServer(mainctx, config, cb)
-
-	manager := snapshot.NewManager(config)
-	update := generateWatcher(mainctx)
-	go manager.Discover(update, mainctx)
+}
 
-	// Run xDS management server
-	go runManagementServer(mainctx, srv, xdsPort)
+func newElector() election.Elector {
+	if conf.Lease.File == "" {
+		return election.AlwaysLeader{}
+	}
+	return election.NewFileLease(conf.Lease.File, conf.Lease.ID, conf.Lease.Duration)
+}
 
-	waitForSignal(mainctx)
+func defaultLeaseID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
 }
 
-func runManagementServer(ctx context.Context, srv server.Server, port uint) {
+func runManagementServer(ctx context.Context, srv server.Server, accessLogs *accesslog.Server, envoyStats *metrics.EnvoyStats, port uint, onListen func()) {
+	grpcConf := conf.GRPC
 	var grpcOptions []grpc.ServerOption
 	grpcOptions = append(grpcOptions,
-		grpc.MaxConcurrentStreams(grpcMaxConcurrentStreams),
+		grpc.MaxConcurrentStreams(grpcConf.MaxConcurrentStreams),
 		grpc.KeepaliveParams(keepalive.ServerParameters{
-			Time:    grpcKeepaliveTime,
-			Timeout: grpcKeepaliveTimeout,
+			Time:    grpcConf.KeepaliveTime,
+			Timeout: grpcConf.KeepaliveTimeout,
 		}),
 		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
-			MinTime:             grpcKeepaliveMinTime,
+			MinTime:             grpcConf.KeepaliveMinTime,
 			PermitWithoutStream: true,
 		}),
 	)
+	if tlsConf := conf.TLS; tlsConf.CertFile != "" {
+		serverTLS, err := pki.ServerTLSConfig(tlsConf.CertFile, tlsConf.KeyFile, tlsConf.ClientCAFile)
+		if err != nil {
+			logrus.Fatalf("xDS server TLS: %v", err)
+		}
+		grpcOptions = append(grpcOptions, grpc.Creds(credentials.NewTLS(serverTLS)))
+		logrus.Infof("xDS Management server requires TLS, client certificates required: %t", tlsConf.ClientCAFile != "")
+	}
 	grpcServer := grpc.NewServer(grpcOptions...)
 
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
@@ -99,19 +375,32 @@ func runManagementServer(ctx context.Context, srv server.Server, port uint) {
 		logrus.Fatalf(err.Error())
 	}
 
-	registerServices(grpcServer, srv)
+	registerServices(grpcServer, srv, accessLogs, envoyStats)
 
 	logrus.Infof("xDS Management server listening on %d", port)
+	onListen()
 	go func() {
 		if err = grpcServer.Serve(lis); err != nil {
 			logrus.Errorf(err.Error())
 		}
 	}()
 	<-ctx.Done()
-	grpcServer.GracefulStop()
+
+	// The xDS server ends its streams once ctx is done, give them until the deadline
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(grpcConf.ShutdownTimeout):
+		logrus.Warnf("xDS streams did not drain within %s, closing them", grpcConf.ShutdownTimeout)
+		grpcServer.Stop()
+	}
 }
 
-func registerServices(grpcServer *grpc.Server, srv server.Server) {
+func registerServices(grpcServer *grpc.Server, srv server.Server, accessLogs *accesslog.Server, envoyStats *metrics.EnvoyStats) {
 	discoverygrpc.RegisterAggregatedDiscoveryServiceServer(grpcServer, srv)
 	endpointservice.RegisterEndpointDiscoveryServiceServer(grpcServer, srv)
 	clusterservice.RegisterClusterDiscoveryServiceServer(grpcServer, srv)
@@ -119,6 +408,9 @@ func registerServices(grpcServer *grpc.Server, srv server.Server) {
 	listenerservice.RegisterListenerDiscoveryServiceServer(grpcServer, srv)
 	// secretservice.RegisterSecretDiscoveryServiceServer(grpcServer, srv)
 	runtimeservice.RegisterRuntimeDiscoveryServiceServer(grpcServer, srv)
+	extensionservice.RegisterExtensionConfigDiscoveryServiceServer(grpcServer, srv)
+	accesslogservice.RegisterAccessLogServiceServer(grpcServer, accessLogs)
+	metricsservice.RegisterMetricsServiceServer(grpcServer, envoyStats)
 }
 
 /* Function generateWatcher:
@@ -126,15 +418,21 @@ func registerServices(grpcServer *grpc.Server, srv server.Server) {
  */
 func generateWatcher(ctx context.Context) chan snapshot.ServiceLabels {
 	updateChannel := make(chan snapshot.ServiceLabels)
+	cli := newDockerClient()
 
-	go watcher.StartWatcher(
-		ctx,
-		newDockerClient(),
-		ingressNetwork,
-		updateChannel,
-	)
+	go func() {
+		watcher.StartWatcher(
+			ctx,
+			cli,
+			conf.IngressNetwork,
+			updateChannel,
+		)
+		if err := cli.Close(); err != nil {
+			logrus.Errorf("Closing Docker client: %v", err)
+		}
+	}()
 
-	go watcher.InitUpdateChannel(updateChannel)
+	go watcher.InitUpdateChannel(ctx, updateChannel)
 
 	return updateChannel
 }
@@ -156,11 +454,11 @@ func newDockerClient() *docker.Client {
 	return c
 }
 
-func waitForSignal(ctx context.Context) {
+func waitForSignal() {
 	s := make(chan os.Signal, 1)
 	signal.Notify(s, syscall.SIGINT, syscall.SIGTERM)
 
-	<-s
-	logrus.Infof("Shutting down control plane upon receiving SIGINT...")
-	ctx.Done()
+	sig := <-s
+	signal.Stop(s) // a second signal kills the process right away
+	logrus.Infof("Shutting down control plane upon receiving %s...", sig)
 }
@@ -0,0 +1,84 @@
+package accesslog_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"envoy-swarm-control/pkg/accesslog"
+)
+
+func TestRotatingFile(t *testing.T) {
+	t.Parallel()
+
+	type entry struct {
+		Seq  int    `json:"seq"`
+		Path string `json:"path"`
+	}
+	line, _ := json.Marshal(entry{Path: "/api"})
+	lineSize := int64(len(line) + 1)
+
+	tests := []struct {
+		name     string
+		maxFiles int
+		files    []string // files expected to exist
+		missing  []string
+	}{
+		{"rotated", 2, []string{"access.log", "access.log.1", "access.log.2"}, []string{"access.log.3"}},
+		{"no rotated files kept", 0, []string{"access.log"}, []string{"access.log.1"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "access.log")
+			r, err := accesslog.NewRotatingFile(path, 2*lineSize, test.maxFiles)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for seq := 0; seq < 9; seq++ { // two lines per file, five files
+				if err := r.WriteJSON(entry{Seq: seq, Path: "/api"}); err != nil {
+					t.Fatalf("RotatingFile.WriteJSON() error: %v", err)
+				}
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range test.files {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Errorf("%s: %v", name, err)
+				} else if info.Size() > 2*lineSize {
+					t.Errorf("%s is %d bytes, above the limit of %d", name, info.Size(), 2*lineSize)
+				}
+			}
+			for _, name := range test.missing {
+				if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+					t.Errorf("%s exists, error: %v", name, err)
+				}
+			}
+
+			// The current file holds the last line written
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var last entry
+			for scanner := bufio.NewScanner(f); scanner.Scan(); {
+				if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if last.Seq != 8 {
+				t.Errorf("last line of %s has seq %d, want 8", path, last.Seq)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	data "github.com/envoyproxy/go-control-plane/envoy/data/accesslog/v3"
+	als "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
+)
+
+const (
+	defaultRecentLimit = 100
+	maxServices        = 1000 // services kept in memory, the least recently logged being dropped first
+)
+
+/* Structure Entry:
+ * an HTTP request as logged by an Envoy. Service is the log name the
+ * generated listeners set, i.e., the swarm service.
+ */
+type Entry struct {
+	Time              time.Time `json:"time"`
+	Node              string    `json:"node"`
+	Service           string    `json:"service"`
+	Method            string    `json:"method"`
+	Authority         string    `json:"authority"`
+	Path              string    `json:"path"`
+	Status            uint32    `json:"status"`
+	DurationMillis    float64   `json:"duration_ms"`
+	BytesSent         uint64    `json:"bytes_sent"`
+	UpstreamCluster   string    `json:"upstream_cluster,omitempty"`
+	ResponseDetails   string    `json:"response_details,omitempty"`
+	DownstreamAddress string    `json:"downstream_address,omitempty"`
+	RequestID         string    `json:"request_id,omitempty"`
+}
+
+/* Structure ServiceSummary:
+ * what the access log service received for one service.
+ */
+type ServiceSummary struct {
+	Service   string            `json:"service"`
+	Nodes     []string          `json:"nodes"` // nodes streaming entries of the service
+	Requests  uint64            `json:"requests"`
+	Statuses  map[string]uint64 `json:"statuses"` // status class, e.g., 5xx -> requests
+	LastEntry time.Time         `json:"last_entry"`
+}
+
+type serviceLog struct {
+	summary  ServiceSummary
+	nodes    map[string]int // node -> open streams logging for the service
+	recent   []Entry        // ring buffer of the last entries
+	next     int
+	lastSeen time.Time // when an entry was last received, as Envoy may not send start times
+}
+
+/* Structure Server:
+ * an Envoy AccessLogService keeping the last entries of up to maxServices
+ * services in memory and, optionally, appending all of them to a rotating
+ * file. Authorize, when set, checks the node of every stream, e.g.,
+ * against its client certificate as for xDS streams.
+ */
+type Server struct {
+	bufferSize int
+	file       *RotatingFile // optional
+	Authorize  func(ctx context.Context, node *core.Node) error
+
+	mu       sync.Mutex
+	services map[string]*serviceLog
+}
+
+var _ als.AccessLogServiceServer = &Server{}
+
+func NewServer(bufferSize int, file *RotatingFile) *Server {
+	return &Server{
+		bufferSize: bufferSize,
+		file:       file,
+		services:   make(map[string]*serviceLog),
+	}
+}
+
+/* Function StreamAccessLogs:
+ * receives the entries of one Envoy. Only the first message of a stream
+ * carries the identifier of the node and log.
+ */
+func (s *Server) StreamAccessLogs(stream als.AccessLogService_StreamAccessLogsServer) error {
+	var nodeID, logName string
+	defer func() {
+		if nodeID != "" {
+			s.detach(nodeID, logName)
+		}
+	}()
+
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&als.StreamAccessLogsResponse{})
+		}
+		if err != nil {
+			return err
+		}
+		if id := msg.GetIdentifier(); id != nil {
+			if s.Authorize != nil && id.GetNode().GetId() != nodeID {
+				if err := s.Authorize(stream.Context(), id.GetNode()); err != nil {
+					return err
+				}
+			}
+			if id.GetNode().GetId() != nodeID || id.GetLogName() != logName {
+				if nodeID != "" {
+					s.detach(nodeID, logName)
+				}
+				nodeID, logName = id.GetNode().GetId(), id.GetLogName()
+				if nodeID != "" {
+					s.attach(nodeID, logName)
+				}
+			}
+		}
+		if nodeID == "" {
+			continue // the identifier never came
+		}
+
+		for _, e := range msg.GetHttpLogs().GetLogEntry() {
+			s.record(toEntry(nodeID, logName, e))
+		}
+	}
+}
+
+func toEntry(nodeID, logName string, e *data.HTTPAccessLogEntry) Entry {
+	common := e.GetCommonProperties()
+	entry := Entry{
+		Node:            nodeID,
+		Service:         logName,
+		Method:          e.GetRequest().GetRequestMethod().String(),
+		Authority:       e.GetRequest().GetAuthority(),
+		Path:            e.GetRequest().GetPath(),
+		Status:          e.GetResponse().GetResponseCode().GetValue(),
+		BytesSent:       e.GetResponse().GetResponseBodyBytes(),
+		UpstreamCluster: common.GetUpstreamCluster(),
+		ResponseDetails: e.GetResponse().GetResponseCodeDetails(),
+		RequestID:       e.GetRequest().GetRequestId(),
+	}
+	if t := common.GetStartTime(); t != nil {
+		entry.Time = t.AsTime()
+	}
+	if d := common.GetTimeToLastDownstreamTxByte(); d != nil {
+		entry.DurationMillis = float64(d.AsDuration().Microseconds()) / 1000
+	}
+	if addr := common.GetDownstreamRemoteAddress().GetSocketAddress(); addr != nil {
+		entry.DownstreamAddress = addr.GetAddress()
+	}
+	return entry
+}
+
+func (s *Server) record(e Entry) {
+	if s.file != nil {
+		if err := s.file.WriteJSON(e); err != nil {
+			logrus.Errorf("Writing access log: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.logOf(e.Service)
+	l.summary.Requests++
+	l.summary.Statuses[fmt.Sprintf("%dxx", e.Status/100)]++
+	if e.Time.After(l.summary.LastEntry) {
+		l.summary.LastEntry = e.Time
+	}
+	l.lastSeen = time.Now()
+	if l.nodes[e.Node] == 0 {
+		l.nodes[e.Node] = 1 // the stream outlived an eviction of the service
+	}
+
+	if s.bufferSize <= 0 {
+		return
+	}
+	if len(l.recent) < s.bufferSize {
+		l.recent = append(l.recent, e)
+		return
+	}
+	l.recent[l.next] = e
+	l.next = (l.next + 1) % s.bufferSize
+}
+
+/* Function logOf:
+ * returns the log of a service, creating it, and evicting another one
+ * if need be, on first use. Must hold s.mu.
+ */
+func (s *Server) logOf(service string) *serviceLog {
+	l, ok := s.services[service]
+	if ok {
+		return l
+	}
+	if len(s.services) >= maxServices {
+		s.evictOldest()
+	}
+	l = &serviceLog{
+		summary:  ServiceSummary{Service: service, Statuses: make(map[string]uint64)},
+		nodes:    make(map[string]int),
+		lastSeen: time.Now(),
+	}
+	s.services[service] = l
+	return l
+}
+
+/* Function attach:
+ * records a node opening a stream logging for a service.
+ */
+func (s *Server) attach(nodeID, service string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logOf(service).nodes[nodeID]++
+}
+
+/* Function detach:
+ * forgets a node for a service once none of its streams log for it.
+ */
+func (s *Server) detach(nodeID, service string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.services[service]
+	if !ok {
+		return
+	}
+	if l.nodes[nodeID]--; l.nodes[nodeID] <= 0 {
+		delete(l.nodes, nodeID)
+	}
+}
+
+/* Function evictOldest:
+ * drops the service logged least recently. Must hold s.mu.
+ */
+func (s *Server) evictOldest() {
+	var oldest string
+	found := false
+	for name, l := range s.services {
+		if !found || l.lastSeen.Before(s.services[oldest].lastSeen) {
+			oldest, found = name, true
+		}
+	}
+	logrus.Debugf("Dropping the access log entries of service %q", oldest)
+	delete(s.services, oldest)
+}
+
+/* Function Summaries:
+ * returns what was received per service, sorted by name.
+ */
+func (s *Server) Summaries() []ServiceSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]ServiceSummary, 0, len(s.services))
+	for _, l := range s.services {
+		summary := l.summary
+		summary.Statuses = make(map[string]uint64, len(l.summary.Statuses))
+		for class, n := range l.summary.Statuses {
+			summary.Statuses[class] = n
+		}
+		for node := range l.nodes {
+			summary.Nodes = append(summary.Nodes, node)
+		}
+		sort.Strings(summary.Nodes)
+		out = append(out, summary)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out
+}
+
+/* Function Recent:
+ * returns up to limit of the last entries of a service, newest first.
+ */
+func (s *Server) Recent(service string, limit int) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.services[service]
+	if !ok {
+		return nil
+	}
+	out := make([]Entry, 0, len(l.recent))
+	for i := 0; i < len(l.recent) && len(out) < limit; i++ {
+		// l.next is the oldest entry once the buffer is full
+		idx := (l.next - 1 - i + 2*len(l.recent)) % len(l.recent)
+		out = append(out, l.recent[idx])
+	}
+	return out
+}
+
+/* Function Handler:
+ * serves the summaries on /accesslogs and the last entries of a service
+ * on /accesslogs/<service>?limit=N.
+ */
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		service := strings.Trim(strings.TrimPrefix(r.URL.Path, "/accesslogs"), "/")
+		if service == "" {
+			writeJSON(w, http.StatusOK, s.Summaries())
+			return
+		}
+
+		limit := defaultRecentLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive integer"})
+				return
+			}
+			limit = n
+		}
+		entries := s.Recent(service, limit)
+		if entries == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no access log entry for service " + service})
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logrus.Errorf("Access log response encoding: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package accesslog
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServer_EvictOldest(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(10, nil)
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < maxServices; i++ {
+		name := fmt.Sprintf("service-%d", i)
+		if i == 0 {
+			name = "" // a log name may be empty
+		}
+		s.record(Entry{Node: "local_node_1", Service: name}) // no start time, as sent by some Envoys
+		s.services[name].lastSeen = base.Add(time.Duration(i) * time.Second)
+	}
+
+	tests := []struct {
+		name    string
+		evicted string
+	}{
+		{"empty log name logged least recently", ""},
+		{"oldest named service", "service-1"},
+	}
+
+	for i, test := range tests {
+		s.record(Entry{Node: "local_node_1", Service: fmt.Sprintf("new-%d", i)})
+		if len(s.services) != maxServices {
+			t.Fatalf("%s: %d services kept, want %d", test.name, len(s.services), maxServices)
+		}
+		if _, ok := s.services[test.evicted]; ok {
+			t.Errorf("%s: service %q was not evicted", test.name, test.evicted)
+		}
+	}
+}
+
+func TestServer_Nodes(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(10, nil)
+	s.attach("local_node_1", "app")
+	s.attach("local_node_2", "app")
+	s.attach("local_node_2", "app") // a second stream of the same node
+	s.record(Entry{Node: "local_node_1", Service: "app", Status: 200})
+
+	tests := []struct {
+		name   string
+		detach string
+		nodes  []string
+	}{
+		{"open streams", "", []string{"local_node_1", "local_node_2"}},
+		{"one stream of a node ended", "local_node_2", []string{"local_node_1", "local_node_2"}},
+		{"every stream of a node ended", "local_node_2", []string{"local_node_1"}},
+		{"all streams ended", "local_node_1", nil},
+	}
+
+	for _, test := range tests {
+		if test.detach != "" {
+			s.detach(test.detach, "app")
+		}
+		summaries := s.Summaries()
+		if len(summaries) != 1 {
+			t.Fatalf("%s: Summaries() = %v, want the summary of app", test.name, summaries)
+		}
+		if !reflect.DeepEqual(summaries[0].Nodes, test.nodes) {
+			t.Errorf("%s: nodes = %v, want %v", test.name, summaries[0].Nodes, test.nodes)
+		}
+		if summaries[0].Requests != 1 {
+			t.Errorf("%s: requests = %d, want 1", test.name, summaries[0].Requests)
+		}
+	}
+}
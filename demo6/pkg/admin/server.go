@@ -0,0 +1,180 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"envoy-swarm-control/pkg/callback"
+	"envoy-swarm-control/pkg/snapshot"
+	"envoy-swarm-control/pkg/xdstypes"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+/* Structure Server:
+ * a read-only HTTP API over the state of the control plane.
+ */
+type Server struct {
+	callbacks     *callback.Callbacks
+	manager       *snapshot.Manager
+	snapshotCache cache.SnapshotCache
+	ready         atomic.Bool
+	mux           *http.ServeMux
+}
+
+/* Structure TypeSnapshot:
+ * the resources of one type URL as served by /snapshots/<node ID>.
+ */
+type TypeSnapshot struct {
+	Version   string                     `json:"version"`
+	Resources map[string]json.RawMessage `json:"resources"`
+}
+
+func NewServer(cb *callback.Callbacks, manager *snapshot.Manager, config cache.SnapshotCache) *Server {
+	s := &Server{
+		callbacks:     cb,
+		manager:       manager,
+		snapshotCache: config,
+		mux:           http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/nodes", s.handleNodes)
+	s.mux.HandleFunc("/snapshots/", s.handleSnapshot)
+	s.mux.HandleFunc("/services", s.handleServices)
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	return s
+}
+
+/* Function SetReady:
+ * flips the result of /readyz, e.g., once the xDS server is listening.
+ */
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+/* Function Handle:
+ * mounts an additional handler on the admin mux.
+ */
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+/* Function Run:
+ * serves the admin API until the context is cancelled.
+ */
+func (s *Server) Run(ctx context.Context, address string, port uint) {
+	srv := &http.Server{
+		Addr:              net.JoinHostPort(address, strconv.FormatUint(uint64(port), 10)),
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	logrus.Infof("Admin server listening on %s", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logrus.Errorf("Admin server: %v", err)
+	}
+}
+
+func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.callbacks.Nodes())
+}
+
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	nodeID := strings.TrimPrefix(r.URL.Path, "/snapshots/")
+	if nodeID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing node ID"})
+		return
+	}
+
+	snap, err := s.snapshotCache.GetSnapshot(nodeID)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	out, err := RenderSnapshot(snap)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleServices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.manager.Services())
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+/* Function RenderSnapshot:
+ * converts every resource of a snapshot to its protojson form,
+ * grouped by type URL. Secrets are redacted.
+ */
+func RenderSnapshot(snap cache.ResourceSnapshot) (map[string]TypeSnapshot, error) {
+	out := make(map[string]TypeSnapshot)
+	for _, typ := range xdstypes.ResourceTypes {
+		items := snap.GetResources(typ)
+		if len(items) == 0 {
+			continue
+		}
+
+		ts := TypeSnapshot{
+			Version:   snap.GetVersion(typ),
+			Resources: make(map[string]json.RawMessage, len(items)),
+		}
+		for name, res := range items {
+			if typ == resource.SecretType { // never hand out key material
+				ts.Resources[name] = json.RawMessage(`{"redacted":true}`)
+				continue
+			}
+			b, err := protojson.Marshal(res)
+			if err != nil {
+				return nil, fmt.Errorf("rendering %s %s: %w", typ, name, err)
+			}
+			ts.Resources[name] = b
+		}
+		out[typ] = ts
+	}
+	return out, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logrus.Errorf("Admin response encoding: %v", err)
+	}
+}
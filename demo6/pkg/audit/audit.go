@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/* Structure Entry:
+ * one line of the audit log. Node and Service are kept at the top level
+ * so that entries of every kind can be filtered the same way.
+ */
+type Entry struct {
+	Time    time.Time   `json:"time"`
+	Kind    string      `json:"kind"`
+	Node    string      `json:"node,omitempty"`
+	Service string      `json:"service,omitempty"`
+	Detail  interface{} `json:"detail,omitempty"`
+}
+
+/* Structure Log:
+ * an append-only file of JSON lines. A nil *Log discards every entry, so
+ * callers do not need to check whether auditing is enabled.
+ */
+type Log struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (l *Log) Record(e Entry) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(e); err != nil {
+		logrus.Errorf("Writing audit log: %v", err)
+	}
+}
+
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+/* Structure RawEntry:
+ * an entry read back from the log, its detail left for the caller to
+ * decode according to the kind.
+ */
+type RawEntry struct {
+	Time    time.Time       `json:"time"`
+	Kind    string          `json:"kind"`
+	Node    string          `json:"node,omitempty"`
+	Service string          `json:"service,omitempty"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+/* Structure Filter:
+ * selects entries; empty fields match everything.
+ */
+type Filter struct {
+	Kinds   []string
+	Node    string
+	Service string
+	Since   time.Time
+}
+
+func (f Filter) Match(e RawEntry) bool {
+	if f.Node != "" && e.Node != f.Node {
+		return false
+	}
+	if f.Service != "" && e.Service != f.Service {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if len(f.Kinds) == 0 {
+		return true
+	}
+	for _, kind := range f.Kinds {
+		if e.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+/* Function Read:
+ * returns the entries of a log matching the filter, oldest first.
+ */
+func Read(r io.Reader, f Filter) ([]RawEntry, error) {
+	var out []RawEntry
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var e RawEntry
+		err := decoder.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", line, err)
+		}
+		if f.Match(e) {
+			out = append(out, e)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package bootstrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gopkg.in/yaml.v3"
+
+	accesslog "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	bootstrap "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	metrics "github.com/envoyproxy/go-control-plane/envoy/config/metrics/v3"
+	overload "github.com/envoyproxy/go-control-plane/envoy/config/overload/v3"
+	stream "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/stream/v3"
+	fixedheap "github.com/envoyproxy/go-control-plane/envoy/extensions/resource_monitors/fixed_heap/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	upstreamhttp "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+)
+
+const (
+	xdsConnectTimeout = 250 * time.Millisecond
+	shrinkHeapAt      = 0.95 // fractions of MaxHeapBytes triggering the overload actions
+	stopRequestsAt    = 0.98
+)
+
+/* Structure Options:
+ * what differs between the bootstraps of mesh nodes.
+ */
+type Options struct {
+	NodeID    string
+	Cluster   string
+	AdminPort uint32
+
+	XDSHost        string
+	XDSPort        uint32
+	XDSClusterName string // must match configresource.XDSClusterName
+	Delta          bool
+
+	// HTTP/2 pings towards the control plane; the interval must not be below
+	// the keepalive enforcement of the xDS server
+	KeepaliveInterval time.Duration
+	KeepaliveTimeout  time.Duration
+
+	TLS *TLS // nil connects to the control plane in plaintext
+
+	RuntimeLayer   string        // RTDS layer fetched over ADS, empty for none
+	StatsSink      bool          // stream stats to the metrics service of the control plane
+	StatsFlush     time.Duration // interval of the stats flushes, zero for Envoy's default
+	MaxConnections uint64        // runtime limit of downstream connections, zero for none
+	MaxHeapBytes   uint64        // overload manager heap limit, zero for none
+}
+
+/* Structure TLS:
+ * paths of the node's credentials inside the Envoy container.
+ */
+type TLS struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	SNI      string
+}
+
+func (o Options) validate() error {
+	switch {
+	case o.NodeID == "":
+		return errors.New("node ID is required")
+	case o.Cluster == "":
+		return errors.New("node cluster is required")
+	case o.XDSHost == "" || o.XDSPort == 0:
+		return errors.New("xDS address is required")
+	case o.XDSClusterName == "":
+		return errors.New("xDS cluster name is required")
+	}
+	return nil
+}
+
+/* Function Build:
+ * returns the bootstrap of a node fetching everything else over ADS.
+ */
+func Build(o Options) (*bootstrap.Bootstrap, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	xdsCluster, err := makeXDSCluster(o)
+	if err != nil {
+		return nil, err
+	}
+	adminLog, err := anypb.New(&stream.StdoutAccessLog{})
+	if err != nil {
+		return nil, err
+	}
+
+	apiType := core.ApiConfigSource_GRPC
+	if o.Delta {
+		apiType = core.ApiConfigSource_DELTA_GRPC
+	}
+	ads := &core.ConfigSource{
+		ResourceApiVersion:    resource.DefaultAPIVersion,
+		ConfigSourceSpecifier: &core.ConfigSource_Ads{Ads: &core.AggregatedConfigSource{}},
+	}
+
+	b := &bootstrap.Bootstrap{
+		Node: &core.Node{Id: o.NodeID, Cluster: o.Cluster},
+		Admin: &bootstrap.Admin{
+			AccessLog: []*accesslog.AccessLog{{
+				Name:       "envoy.access_loggers.stdout",
+				ConfigType: &accesslog.AccessLog_TypedConfig{TypedConfig: adminLog},
+			}},
+			Address: socketAddress("0.0.0.0", o.AdminPort),
+		},
+		LayeredRuntime: makeRuntime(o, ads),
+		DynamicResources: &bootstrap.Bootstrap_DynamicResources{
+			AdsConfig: &core.ApiConfigSource{
+				ApiType:             apiType,
+				TransportApiVersion: resource.DefaultAPIVersion,
+				GrpcServices: []*core.GrpcService{{
+					TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+						EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: o.XDSClusterName},
+					},
+				}},
+			},
+			CdsConfig: ads,
+			LdsConfig: ads,
+		},
+		StaticResources: &bootstrap.Bootstrap_StaticResources{
+			Clusters: []*cluster.Cluster{xdsCluster},
+		},
+	}
+
+	if o.StatsSink {
+		if b.StatsSinks, err = makeStatsSinks(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.StatsFlush > 0 {
+		b.StatsFlushInterval = durationpb.New(o.StatsFlush)
+	}
+
+	if o.MaxHeapBytes > 0 {
+		if b.OverloadManager, err = makeOverloadManager(o.MaxHeapBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := b.ValidateAll(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func makeXDSCluster(o Options) (*cluster.Cluster, error) {
+	protocolOptions, err := anypb.New(&upstreamhttp.HttpProtocolOptions{
+		UpstreamProtocolOptions: &upstreamhttp.HttpProtocolOptions_ExplicitHttpConfig_{
+			ExplicitHttpConfig: &upstreamhttp.HttpProtocolOptions_ExplicitHttpConfig{
+				ProtocolConfig: &upstreamhttp.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{
+					Http2ProtocolOptions: &core.Http2ProtocolOptions{
+						ConnectionKeepalive: &core.KeepaliveSettings{
+							Interval: durationpb.New(o.KeepaliveInterval),
+							Timeout:  durationpb.New(o.KeepaliveTimeout),
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cluster.Cluster{
+		Name:                 o.XDSClusterName,
+		ConnectTimeout:       durationpb.New(xdsConnectTimeout),
+		ClusterDiscoveryType: &cluster.Cluster_Type{Type: cluster.Cluster_STRICT_DNS},
+		LbPolicy:             cluster.Cluster_ROUND_ROBIN,
+		// Detect half open connections to the control plane and reconnect
+		UpstreamConnectionOptions: &cluster.UpstreamConnectionOptions{
+			TcpKeepalive: &core.TcpKeepalive{},
+		},
+		LoadAssignment: &endpoint.ClusterLoadAssignment{
+			ClusterName: o.XDSClusterName,
+			Endpoints: []*endpoint.LocalityLbEndpoints{{
+				LbEndpoints: []*endpoint.LbEndpoint{{
+					HostIdentifier: &endpoint.LbEndpoint_Endpoint{
+						Endpoint: &endpoint.Endpoint{Address: socketAddress(o.XDSHost, o.XDSPort)},
+					},
+				}},
+			}},
+		},
+		TypedExtensionProtocolOptions: map[string]*anypb.Any{
+			"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": protocolOptions,
+		},
+	}
+
+	if o.TLS != nil {
+		tlsContext, err := anypb.New(&tls.UpstreamTlsContext{
+			Sni: o.TLS.SNI,
+			CommonTlsContext: &tls.CommonTlsContext{
+				TlsCertificates: []*tls.TlsCertificate{{
+					CertificateChain: fileSource(o.TLS.CertFile),
+					PrivateKey:       fileSource(o.TLS.KeyFile),
+				}},
+				ValidationContextType: &tls.CommonTlsContext_ValidationContext{
+					ValidationContext: &tls.CertificateValidationContext{
+						TrustedCa: fileSource(o.TLS.CAFile),
+					},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.TransportSocket = &core.TransportSocket{
+			Name:       wellknown.TransportSocketTLS,
+			ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: tlsContext},
+		}
+	}
+	return c, nil
+}
+
+/* Function makeRuntime:
+ * a static layer with the node's limits, the RTDS layer served by the
+ * control plane on top of it, and an admin layer, so that runtime values
+ * can still be overridden through the admin interface.
+ */
+func makeRuntime(o Options, ads *core.ConfigSource) *bootstrap.LayeredRuntime {
+	static := map[string]interface{}{}
+	if o.MaxConnections > 0 {
+		// Limits connections to prevent file descriptor exhaustion
+		static["overload"] = map[string]interface{}{
+			"global_downstream_max_connections": o.MaxConnections,
+		}
+	}
+	staticLayer, _ := structpb.NewStruct(static) // only holds numbers and maps
+
+	layers := []*bootstrap.RuntimeLayer{{
+		Name:           "static_layer_0",
+		LayerSpecifier: &bootstrap.RuntimeLayer_StaticLayer{StaticLayer: staticLayer},
+	}}
+	if o.RuntimeLayer != "" {
+		layers = append(layers, &bootstrap.RuntimeLayer{
+			Name: o.RuntimeLayer,
+			LayerSpecifier: &bootstrap.RuntimeLayer_RtdsLayer_{RtdsLayer: &bootstrap.RuntimeLayer_RtdsLayer{
+				Name:       o.RuntimeLayer,
+				RtdsConfig: ads,
+			}},
+		})
+	}
+	layers = append(layers, &bootstrap.RuntimeLayer{
+		Name:           "admin_layer",
+		LayerSpecifier: &bootstrap.RuntimeLayer_AdminLayer_{AdminLayer: &bootstrap.RuntimeLayer_AdminLayer{}},
+	})
+	return &bootstrap.LayeredRuntime{Layers: layers}
+}
+
+/* Function makeStatsSinks:
+ * streams the stats to the metrics service of the control plane over the
+ * xDS cluster, tags as labels and histograms as such, as re-exposed to
+ * Prometheus.
+ */
+func makeStatsSinks(o Options) ([]*metrics.StatsSink, error) {
+	sink, err := anypb.New(&metrics.MetricsServiceConfig{
+		GrpcService: &core.GrpcService{
+			TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+				EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: o.XDSClusterName},
+			},
+		},
+		TransportApiVersion: resource.DefaultAPIVersion,
+		EmitTagsAsLabels:    true,
+		HistogramEmitMode:   metrics.HistogramEmitMode_HISTOGRAM,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []*metrics.StatsSink{{
+		Name:       "envoy.stat_sinks.metrics_service",
+		ConfigType: &metrics.StatsSink_TypedConfig{TypedConfig: sink},
+	}}, nil
+}
+
+func makeOverloadManager(maxHeapBytes uint64) (*overload.OverloadManager, error) {
+	heap, err := anypb.New(&fixedheap.FixedHeapConfig{MaxHeapSizeBytes: maxHeapBytes})
+	if err != nil {
+		return nil, err
+	}
+
+	const monitor = "envoy.resource_monitors.fixed_heap"
+	trigger := func(value float64) []*overload.Trigger {
+		return []*overload.Trigger{{
+			Name:         monitor,
+			TriggerOneof: &overload.Trigger_Threshold{Threshold: &overload.ThresholdTrigger{Value: value}},
+		}}
+	}
+	return &overload.OverloadManager{
+		RefreshInterval: durationpb.New(250 * time.Millisecond),
+		ResourceMonitors: []*overload.ResourceMonitor{{
+			Name:       monitor,
+			ConfigType: &overload.ResourceMonitor_TypedConfig{TypedConfig: heap},
+		}},
+		Actions: []*overload.OverloadAction{
+			{Name: "envoy.overload_actions.shrink_heap", Triggers: trigger(shrinkHeapAt)},
+			{Name: "envoy.overload_actions.stop_accepting_requests", Triggers: trigger(stopRequestsAt)},
+		},
+	}, nil
+}
+
+func socketAddress(host string, port uint32) *core.Address {
+	return &core.Address{
+		Address: &core.Address_SocketAddress{
+			SocketAddress: &core.SocketAddress{
+				Address:       host,
+				PortSpecifier: &core.SocketAddress_PortValue{PortValue: port},
+			},
+		},
+	}
+}
+
+func fileSource(path string) *core.DataSource {
+	return &core.DataSource{Specifier: &core.DataSource_Filename{Filename: path}}
+}
+
+/* Function Render:
+ * encodes a bootstrap as JSON or YAML with the field names of the Envoy
+ * documentation, e.g., static_resources.
+ */
+func Render(b proto.Message, format string) ([]byte, error) {
+	data, err := protojson.MarshalOptions{UseProtoNames: true, Indent: "  "}.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "json":
+		return append(data, '\n'), nil
+	case "yaml":
+		// Decoding into a node keeps the field order of the JSON document
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		blockStyle(&doc)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&doc); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), enc.Close()
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected yaml or json", format)
+	}
+}
+
+/* Function blockStyle:
+ * switches the flow style inherited from JSON to YAML's block style.
+ */
+func blockStyle(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode || n.Kind == yaml.SequenceNode {
+		n.Style = 0
+	}
+	if n.Kind == yaml.ScalarNode && n.Style == yaml.DoubleQuotedStyle && !needsQuotes(n.Value) {
+		n.Style = 0
+	}
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+/* Function needsQuotes:
+ * tells whether a JSON string would change type or meaning unquoted.
+ */
+func needsQuotes(s string) bool {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return true
+	}
+	str, ok := v.(string)
+	if !ok || str != s {
+		return true
+	}
+	_, err := json.Number(s).Float64()
+	return err == nil
+}
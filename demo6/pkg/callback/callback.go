@@ -2,15 +2,49 @@ package callback
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"time"
+
+	"envoy-swarm-control/pkg/audit"
+	"envoy-swarm-control/pkg/metrics"
 
 	"github.com/sirupsen/logrus"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
 
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	server "github.com/envoyproxy/go-control-plane/pkg/server/v3"
 )
 
+/* Interface AckHandler:
+ * is notified whenever an Envoy node accepts (ACK) or rejects (NACK)
+ * a configuration version pushed by the management server.
+ */
+type AckHandler interface {
+	OnAck(nodeID, typeURL, version string)
+	OnNack(nodeID, typeURL, version string, detail *rpcstatus.Status)
+}
+
+/* Structure AckStatus:
+ * the outcome of the last response of a given type URL sent to a node.
+ */
+type AckStatus struct {
+	Version     string // version accepted or rejected by the node
+	Acked       bool
+	ErrorDetail string // NACK error message, empty on ACK
+	UpdatedAt   time.Time
+}
+
+/* Structure NodeStatus:
+ * a connected node, its open streams and the last ACK/NACK per type URL.
+ */
+type NodeStatus struct {
+	NodeID  string
+	Streams []StreamInfo
+	Acks    map[string]AckStatus
+}
+
 type Callbacks struct {
 	Signal         chan struct{}
 	Debug          bool
@@ -18,7 +52,16 @@ type Callbacks struct {
 	Requests       int
 	DeltaRequests  int
 	DeltaResponses int
-	mu             sync.Mutex // only one goroutine at a time can access callback structure
+	Acks           int
+	Nacks          int
+	Handler        AckHandler // optional, e.g., the snapshot manager
+	// Reject nodes whose ID is not bound to their client certificate, requires mTLS
+	RequireNodeIdentity bool
+	Audit               *audit.Log // optional, records node identity decisions
+	mu                  sync.Mutex // only one goroutine at a time can access callback structure
+
+	streams   map[int64]*StreamInfo           // registry of live streams
+	ackStatus map[string]map[string]AckStatus // node ID -> type URL -> last outcome
 }
 
 var _ server.Callbacks = &Callbacks{}
@@ -26,41 +69,111 @@ var _ server.Callbacks = &Callbacks{}
 func (cb *Callbacks) Report() {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
-	logrus.WithFields(logrus.Fields{"fetches": cb.Fetches, "requests": cb.Requests}).Info("Report() callbacks")
+	logrus.WithFields(logrus.Fields{
+		"fetches":  cb.Fetches,
+		"requests": cb.Requests,
+		"acks":     cb.Acks,
+		"nacks":    cb.Nacks,
+	}).Info("Report() callbacks")
+}
+
+/* Function AckStatus:
+ * returns a copy of the last ACK/NACK outcome per type URL for a node.
+ */
+func (cb *Callbacks) AckStatus(nodeID string) map[string]AckStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	out := make(map[string]AckStatus, len(cb.ackStatus[nodeID]))
+	for typeURL, s := range cb.ackStatus[nodeID] {
+		out[typeURL] = s
+	}
+	return out
+}
+
+/* Function Nodes:
+ * returns every node with at least one open stream, sorted by node ID.
+ */
+func (cb *Callbacks) Nodes() []NodeStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	byNode := make(map[string]*NodeStatus)
+	for _, st := range cb.streams {
+		if st.NodeID == "" { // not identified yet
+			continue
+		}
+		n, ok := byNode[st.NodeID]
+		if !ok {
+			n = &NodeStatus{NodeID: st.NodeID, Acks: make(map[string]AckStatus)}
+			for typeURL, s := range cb.ackStatus[st.NodeID] {
+				n.Acks[typeURL] = s
+			}
+			byNode[st.NodeID] = n
+		}
+		n.Streams = append(n.Streams, st.clone())
+	}
+
+	out := make([]NodeStatus, 0, len(byNode))
+	for _, n := range byNode {
+		sort.Slice(n.Streams, func(i, j int) bool { return n.Streams[i].ID < n.Streams[j].ID })
+		out = append(out, *n)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].NodeID < out[j].NodeID })
+	return out
 }
 
-func (cb *Callbacks) OnStreamOpen(_ context.Context, id int64, typ string) error {
+func (cb *Callbacks) OnStreamOpen(ctx context.Context, id int64, typ string) error {
 	logrus.Infof("OnStreamOpen %d of type %v", id, typ)
-	return nil
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.openStream(ctx, id, false)
+	return cb.requireCertificate(id)
 }
 
 func (cb *Callbacks) OnStreamClosed(id int64, node *core.Node) {
-	logrus.Infof("OnStreamClosed %d for node %s", id, node.Id)
+	logrus.Infof("OnStreamClosed %d for node %s", id, node.GetId())
+	cb.forgetStream(id)
 }
 
-func (cb *Callbacks) OnDeltaStreamOpen(_ context.Context, id int64, typ string) error {
+func (cb *Callbacks) OnDeltaStreamOpen(ctx context.Context, id int64, typ string) error {
 	logrus.Infof("OnDeltaStreamOpen %d of type %s", id, typ)
-	return nil
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.openStream(ctx, id, true)
+	return cb.requireCertificate(id)
 }
 
 func (cb *Callbacks) OnDeltaStreamClosed(id int64, node *core.Node) {
-	logrus.Infof("OnDeltaStreamClosed %d for node %s", id, node.Id)
+	logrus.Infof("OnDeltaStreamClosed %d for node %s", id, node.GetId())
+	cb.forgetStream(id)
 }
 
 func (cb *Callbacks) OnStreamRequest(id int64, req *discoverygrpc.DiscoveryRequest) error {
 	logrus.Infof("OnStreamRequest %d Request [%v]", id, req.TypeUrl)
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	if err := cb.authorizeStream(id, req.Node); err != nil {
+		cb.mu.Unlock()
+		return err
+	}
 	cb.Requests++
 	if cb.Signal != nil {
 		close(cb.Signal)
 		cb.Signal = nil
 	}
+	notify := cb.observeRequest(id, req.Node, req.TypeUrl, req.ResponseNonce, req.VersionInfo, req.ErrorDetail)
+	cb.stream(id).subscribe(req.TypeUrl, req.ResourceNames)
+	cb.mu.Unlock()
+
+	notify()
 	return nil
 }
 
 func (cb *Callbacks) OnStreamResponse(ctx context.Context, id int64, req *discoverygrpc.DiscoveryRequest, res *discoverygrpc.DiscoveryResponse) {
 	logrus.Infof("OnStreamResponse... %d Request [%v], Response [%v]", id, req.TypeUrl, res.TypeUrl)
+	cb.mu.Lock()
+	cb.observeResponse(id, res.TypeUrl, res.Nonce, res.VersionInfo)
+	cb.mu.Unlock()
 	cb.Report()
 }
 
@@ -69,17 +182,27 @@ func (cb *Callbacks) OnStreamDeltaResponse(id int64, req *discoverygrpc.DeltaDis
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 	cb.DeltaResponses++
+	cb.observeResponse(id, res.TypeUrl, res.Nonce, res.SystemVersionInfo)
 }
 
 func (cb *Callbacks) OnStreamDeltaRequest(id int64, req *discoverygrpc.DeltaDiscoveryRequest) error {
 	logrus.Infof("OnStreamDeltaRequest... %d Request [%v]", id, req.TypeUrl)
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	if err := cb.authorizeStream(id, req.Node); err != nil {
+		cb.mu.Unlock()
+		return err
+	}
 	cb.DeltaRequests++
 	if cb.Signal != nil {
 		close(cb.Signal)
 		cb.Signal = nil
 	}
+	// Delta requests carry no accepted version, the nonce alone identifies the response
+	notify := cb.observeRequest(id, req.Node, req.TypeUrl, req.ResponseNonce, "", req.ErrorDetail)
+	cb.stream(id).subscribeDelta(req.TypeUrl, req.ResourceNamesSubscribe, req.ResourceNamesUnsubscribe)
+	cb.mu.Unlock()
+
+	notify()
 	return nil
 }
 
@@ -87,7 +210,11 @@ func (cb *Callbacks) OnFetchRequest(ctx context.Context, req *discoverygrpc.Disc
 	logrus.Infof("OnFetchRequest... Request [%v]", req.TypeUrl)
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
+	if err := cb.AuthorizeNode(ctx, req.Node); err != nil {
+		return err
+	}
 	cb.Fetches++
+	metrics.Requests.WithLabelValues(req.Node.GetId(), req.TypeUrl).Inc()
 	if cb.Signal != nil {
 		close(cb.Signal)
 		cb.Signal = nil
@@ -98,3 +225,114 @@ func (cb *Callbacks) OnFetchRequest(ctx context.Context, req *discoverygrpc.Disc
 func (cb *Callbacks) OnFetchResponse(req *discoverygrpc.DiscoveryRequest, res *discoverygrpc.DiscoveryResponse) {
 	logrus.Infof("OnFetchResponse... Request [%v], Response [%v]", req.TypeUrl, res.TypeUrl)
 }
+
+/* Function observeResponse:
+ * remembers which version was sent under which nonce on a stream,
+ * so that a later ACK/NACK can be matched to it. A response supersedes
+ * the previous ones of its type, whose nonces are forgotten: Envoy only
+ * acknowledges the latest. Must hold cb.mu.
+ */
+func (cb *Callbacks) observeResponse(id int64, typeURL, nonce, version string) {
+	s := cb.stream(id)
+	for n, sent := range s.pending {
+		if sent.typeURL == typeURL {
+			delete(s.pending, n)
+		}
+	}
+	s.pending[nonce] = sentResponse{typeURL: typeURL, version: version, sentAt: time.Now()}
+	s.LastNonce[typeURL] = nonce
+	metrics.Responses.WithLabelValues(s.NodeID, typeURL).Inc()
+}
+
+/* Function observeRequest:
+ * classifies a request as ACK or NACK of a previous response and records
+ * the outcome. Must hold cb.mu; the returned function notifies the
+ * AckHandler and must be called after releasing the lock.
+ */
+func (cb *Callbacks) observeRequest(id int64, node *core.Node, typeURL, nonce, acceptedVersion string, detail *rpcstatus.Status) func() {
+	s := cb.stream(id)
+	if s.identify(node) {
+		metrics.OpenStreams.WithLabelValues(s.NodeID).Inc()
+	}
+	nodeID := s.NodeID
+	metrics.Requests.WithLabelValues(nodeID, typeURL).Inc()
+
+	if nonce == "" { // initial request, nothing to acknowledge yet
+		return func() {}
+	}
+
+	sent, ok := s.pending[nonce]
+	if !ok { // stale nonce
+		return func() {}
+	}
+	delete(s.pending, nonce)
+	sentVersion := sent.version
+
+	if cb.ackStatus == nil {
+		cb.ackStatus = make(map[string]map[string]AckStatus)
+	}
+	if cb.ackStatus[nodeID] == nil {
+		cb.ackStatus[nodeID] = make(map[string]AckStatus)
+	}
+
+	handler := cb.Handler
+	if detail != nil {
+		cb.Nacks++
+		metrics.Nacks.WithLabelValues(nodeID, typeURL).Inc()
+		cb.ackStatus[nodeID][typeURL] = AckStatus{
+			Version:     sentVersion,
+			ErrorDetail: detail.GetMessage(),
+			UpdatedAt:   time.Now(),
+		}
+		logrus.WithFields(logrus.Fields{
+			"node":    nodeID,
+			"type":    typeURL,
+			"version": sentVersion,
+			"code":    detail.GetCode(),
+		}).Errorf("NACK received: %s", detail.GetMessage())
+
+		if handler == nil {
+			return func() {}
+		}
+		return func() { handler.OnNack(nodeID, typeURL, sentVersion, detail) }
+	}
+
+	if acceptedVersion == "" {
+		acceptedVersion = sentVersion
+	}
+	cb.Acks++
+	s.LastAcked[typeURL] = acceptedVersion
+	metrics.Acks.WithLabelValues(nodeID, typeURL).Inc()
+	metrics.TimeToAck.WithLabelValues(typeURL).Observe(time.Since(sent.sentAt).Seconds())
+	cb.ackStatus[nodeID][typeURL] = AckStatus{
+		Version:   acceptedVersion,
+		Acked:     true,
+		UpdatedAt: time.Now(),
+	}
+
+	if handler == nil {
+		return func() {}
+	}
+	return func() { handler.OnAck(nodeID, typeURL, acceptedVersion) }
+}
+
+/* Function forgetStream:
+ * unregisters a closed stream, dropping the open streams series of its
+ * node along with its last one so that departed nodes do not linger.
+ */
+func (cb *Callbacks) forgetStream(id int64) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	s, ok := cb.streams[id]
+	delete(cb.streams, id)
+	if !ok || s.NodeID == "" {
+		return
+	}
+	for _, other := range cb.streams {
+		if other.NodeID == s.NodeID {
+			metrics.OpenStreams.WithLabelValues(s.NodeID).Dec()
+			return
+		}
+	}
+	metrics.OpenStreams.DeleteLabelValues(s.NodeID)
+}
@@ -0,0 +1,179 @@
+package callback
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"envoy-swarm-control/pkg/audit"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+)
+
+/* Structure IdentityDecision:
+ * the audit record of checking a node against its client certificate.
+ */
+type IdentityDecision struct {
+	Stream     int64    `json:"stream,omitempty"` // zero for fetches and the access log and metrics services
+	Cluster    string   `json:"cluster,omitempty"`
+	Peer       string   `json:"peer,omitempty"`
+	Identities []string `json:"identities"`
+	Allowed    bool     `json:"allowed"`
+	Reason     string   `json:"reason"`
+}
+
+/* Function peerIdentities:
+ * returns the DNS and URI SANs of the verified client certificate of a
+ * stream, nil if the peer presented none.
+ */
+func peerIdentities(ctx context.Context) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.PeerCertificates) == 0 {
+		return nil
+	}
+
+	leaf := info.State.PeerCertificates[0]
+	identities := append([]string(nil), leaf.DNSNames...)
+	for _, u := range leaf.URIs {
+		identities = append(identities, u.String())
+	}
+	return identities
+}
+
+/* Function matchNode:
+ * accepts a node which is named by a SPIFFE ID of the form
+ * spiffe://<trust domain>/<cluster>/<node ID>, binding its cluster, or
+ * whose ID is a DNS SAN of its certificate. A DNS SAN does not bind the
+ * cluster, so it is only accepted for a node without one, or whose cluster
+ * is its ID as set by the bootstrap command by default.
+ */
+func matchNode(identities []string, node *core.Node) (bool, string) {
+	if len(identities) == 0 {
+		return false, "no verified client certificate"
+	}
+	reason := fmt.Sprintf("node ID %q matches none of the certificate identities", node.GetId())
+	for _, id := range identities {
+		u, err := url.Parse(id)
+		if err != nil || u.Scheme != "spiffe" {
+			if id != node.GetId() {
+				continue
+			}
+			if cluster := node.GetCluster(); cluster != "" && cluster != node.GetId() {
+				reason = fmt.Sprintf("cluster %q is not bound by DNS SAN %s, a SPIFFE ID is required", cluster, id)
+				continue
+			}
+			return true, "node ID matches DNS SAN " + id
+		}
+
+		segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(segments) != 2 || segments[1] != node.GetId() {
+			continue
+		}
+		if segments[0] != node.GetCluster() {
+			return false, fmt.Sprintf("cluster %q does not match SPIFFE ID %s", node.GetCluster(), id)
+		}
+		return true, "node ID and cluster match SPIFFE ID " + id
+	}
+	return false, reason
+}
+
+/* Function requireCertificate:
+ * refuses a stream opened without a verified client certificate before
+ * any node is known. Must hold cb.mu.
+ */
+func (cb *Callbacks) requireCertificate(id int64) error {
+	s := cb.stream(id)
+	if !cb.RequireNodeIdentity || len(s.PeerIdentities) > 0 {
+		return nil
+	}
+
+	cb.auditIdentity(nil, IdentityDecision{
+		Stream: id,
+		Peer:   s.PeerAddress,
+		Reason: "no verified client certificate",
+	})
+	return status.Error(codes.Unauthenticated, "a verified client certificate is required")
+}
+
+/* Function authorizeStream:
+ * checks the node of a request against the certificate of its stream.
+ * Every stream is audited once, when its node is first seen or changes.
+ * Must hold cb.mu.
+ */
+func (cb *Callbacks) authorizeStream(id int64, node *core.Node) error {
+	if !cb.RequireNodeIdentity {
+		return nil
+	}
+	s := cb.stream(id)
+	if node.GetId() == "" || node.GetId() == s.authorizedNode {
+		return nil
+	}
+
+	allowed, reason := matchNode(s.PeerIdentities, node)
+	cb.auditIdentity(node, IdentityDecision{
+		Stream:     id,
+		Cluster:    node.GetCluster(),
+		Peer:       s.PeerAddress,
+		Identities: s.PeerIdentities,
+		Allowed:    allowed,
+		Reason:     reason,
+	})
+	if !allowed {
+		return status.Errorf(codes.PermissionDenied, "node %s: %s", node.GetId(), reason)
+	}
+	s.authorizedNode = node.GetId()
+	return nil
+}
+
+/* Function AuthorizeNode:
+ * same check as authorizeStream for the requests that are not xDS
+ * streams: unary fetches, and the streams of the access log and metrics
+ * services, which identify their node as well.
+ */
+func (cb *Callbacks) AuthorizeNode(ctx context.Context, node *core.Node) error {
+	if !cb.RequireNodeIdentity {
+		return nil
+	}
+
+	identities := peerIdentities(ctx)
+	allowed, reason := matchNode(identities, node)
+	decision := IdentityDecision{
+		Cluster:    node.GetCluster(),
+		Identities: identities,
+		Allowed:    allowed,
+		Reason:     reason,
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		decision.Peer = p.Addr.String()
+	}
+	cb.auditIdentity(node, decision)
+	if !allowed {
+		return status.Errorf(codes.PermissionDenied, "node %s: %s", node.GetId(), reason)
+	}
+	return nil
+}
+
+func (cb *Callbacks) auditIdentity(node *core.Node, decision IdentityDecision) {
+	entry := logrus.WithFields(logrus.Fields{
+		"node":    node.GetId(),
+		"cluster": node.GetCluster(),
+		"peer":    decision.Peer,
+	})
+	if decision.Allowed {
+		entry.Infof("Node identity accepted: %s", decision.Reason)
+	} else {
+		entry.Warnf("Node identity rejected: %s", decision.Reason)
+	}
+	cb.Audit.Record(audit.Entry{Kind: "node-identity", Node: node.GetId(), Detail: decision})
+}
@@ -0,0 +1,40 @@
+package callback
+
+import (
+	"testing"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+)
+
+func TestMatchNode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		identities []string
+		node       *core.Node
+		succeeded  bool
+	}{
+		{"no certificate", nil, &core.Node{Id: "local_node_1"}, false},
+		{"dns san", []string{"local_node_1"}, &core.Node{Id: "local_node_1"}, true},
+		{"dns san with cluster as id", []string{"local_node_1"}, &core.Node{Id: "local_node_1", Cluster: "local_node_1"}, true},
+		{"dns san with another cluster", []string{"local_node_1"}, &core.Node{Id: "local_node_1", Cluster: "payments"}, false},
+		{"dns san of another node", []string{"local_node_2"}, &core.Node{Id: "local_node_1"}, false},
+		{"spiffe id", []string{"spiffe://envoy-swarm/payments/local_node_1"}, &core.Node{Id: "local_node_1", Cluster: "payments"}, true},
+		{"spiffe id of another cluster", []string{"spiffe://envoy-swarm/payments/local_node_1"}, &core.Node{Id: "local_node_1", Cluster: "orders"}, false},
+		{"spiffe id of another node", []string{"spiffe://envoy-swarm/payments/local_node_2"}, &core.Node{Id: "local_node_1", Cluster: "payments"}, false},
+		{"spiffe id after dns san", []string{"local_node_1", "spiffe://envoy-swarm/payments/local_node_1"}, &core.Node{Id: "local_node_1", Cluster: "payments"}, true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			allowed, reason := matchNode(test.identities, test.node)
+			if allowed != test.succeeded {
+				t.Errorf("matchNode() = %v (%s), succeeded: %v", allowed, reason, test.succeeded)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package callback
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/peer"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+)
+
+/* Structure StreamInfo:
+ * what is known about one live xDS stream. Values handed out by the
+ * Callbacks are copies and safe to keep after the stream is closed.
+ */
+type StreamInfo struct {
+	ID             int64
+	Delta          bool
+	NodeID         string
+	Cluster        string
+	Metadata       map[string]interface{} `json:",omitempty"`
+	PeerAddress    string
+	PeerIdentities []string `json:",omitempty"` // SANs of the verified client certificate
+	ConnectedAt    time.Time
+	Subscriptions  map[string][]string // type URL -> resource names, empty for a wildcard subscription
+	LastNonce      map[string]string   // type URL -> nonce of the last response sent
+	LastAcked      map[string]string   // type URL -> last version ACKed on this stream
+
+	pending        map[string]sentResponse // nonce -> last response of its type awaiting ACK/NACK
+	authorizedNode string                  // node ID checked against PeerIdentities
+}
+
+type sentResponse struct {
+	typeURL string
+	version string
+	sentAt  time.Time
+}
+
+/* Function Streams:
+ * returns a copy of every live stream, sorted by stream ID.
+ */
+func (cb *Callbacks) Streams() []StreamInfo {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	out := make([]StreamInfo, 0, len(cb.streams))
+	for _, s := range cb.streams {
+		out = append(out, s.clone())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+/* Function Stream:
+ * returns a copy of a live stream.
+ */
+func (cb *Callbacks) Stream(id int64) (StreamInfo, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	s, ok := cb.streams[id]
+	if !ok {
+		return StreamInfo{}, false
+	}
+	return s.clone(), true
+}
+
+/* Function NodeStreams:
+ * returns a copy of every live stream opened by a node.
+ */
+func (cb *Callbacks) NodeStreams(nodeID string) []StreamInfo {
+	var out []StreamInfo
+	for _, s := range cb.Streams() {
+		if s.NodeID == nodeID {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+/* Function openStream:
+ * registers a new stream with its peer address and certificate
+ * identities. Must hold cb.mu.
+ */
+func (cb *Callbacks) openStream(ctx context.Context, id int64, delta bool) {
+	s := cb.stream(id)
+	s.Delta = delta
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		s.PeerAddress = p.Addr.String()
+	}
+	s.PeerIdentities = peerIdentities(ctx)
+}
+
+/* Function stream:
+ * returns the registry entry of a stream, creating it if needed.
+ * Must hold cb.mu.
+ */
+func (cb *Callbacks) stream(id int64) *StreamInfo {
+	if cb.streams == nil {
+		cb.streams = make(map[int64]*StreamInfo)
+	}
+	s, ok := cb.streams[id]
+	if !ok {
+		s = &StreamInfo{
+			ID:            id,
+			ConnectedAt:   time.Now(),
+			Subscriptions: make(map[string][]string),
+			LastNonce:     make(map[string]string),
+			LastAcked:     make(map[string]string),
+			pending:       make(map[string]sentResponse),
+		}
+		cb.streams[id] = s
+	}
+	return s
+}
+
+/* Function identify:
+ * records the node of a stream; Envoy only sends it on the first request
+ * (SetNodeOnFirstMessageOnly). Returns true the first time a node is set.
+ */
+func (s *StreamInfo) identify(node *core.Node) bool {
+	if node.GetId() == "" {
+		return false
+	}
+	first := s.NodeID == ""
+	s.NodeID = node.GetId()
+	s.Cluster = node.GetCluster()
+	if md := node.GetMetadata(); md != nil {
+		s.Metadata = md.AsMap()
+	}
+	return first
+}
+
+func (s *StreamInfo) subscribe(typeURL string, names []string) {
+	s.Subscriptions[typeURL] = append([]string(nil), names...)
+}
+
+func (s *StreamInfo) subscribeDelta(typeURL string, subscribe, unsubscribe []string) {
+	set := make(map[string]bool)
+	for _, name := range s.Subscriptions[typeURL] {
+		set[name] = true
+	}
+	for _, name := range subscribe {
+		set[name] = true
+	}
+	for _, name := range unsubscribe {
+		delete(set, name)
+	}
+
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s.Subscriptions[typeURL] = names
+}
+
+func (s *StreamInfo) clone() StreamInfo {
+	out := *s
+	out.pending = nil
+	out.Subscriptions = make(map[string][]string, len(s.Subscriptions))
+	for typeURL, names := range s.Subscriptions {
+		out.Subscriptions[typeURL] = append([]string(nil), names...)
+	}
+	out.LastNonce = copyStrings(s.LastNonce)
+	out.LastAcked = copyStrings(s.LastAcked)
+	out.PeerIdentities = append([]string(nil), s.PeerIdentities...)
+	if s.Metadata != nil {
+		out.Metadata = make(map[string]interface{}, len(s.Metadata))
+		for k, v := range s.Metadata {
+			out.Metadata[k] = v
+		}
+	}
+	return out
+}
+
+func copyStrings(in map[string]string) map[string]string {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Envoy accepts HTTP/2 window sizes within [65535, 2^31 - 1]
+const (
+	minHTTP2WindowSize = 65535
+	maxHTTP2WindowSize = 1<<31 - 1
+)
+
+/* Structure Config:
+ * everything a deployment may tune without recompiling. Durations are
+ * written as Go durations, e.g., "30s" or "1h".
+ */
+type Config struct {
+	XDSPort        uint   `yaml:"xds_port"`
+	AdminPort      uint   `yaml:"admin_port"`
+	AdminAddress   string `yaml:"admin_address"` // interface of the admin API, empty for every interface
+	IngressNetwork string `yaml:"ingress_network"`
+	StateDir       string `yaml:"state_dir"` // empty keeps everything in memory
+	Delta          bool   `yaml:"delta"`
+	CertPath       string `yaml:"cert_path"`        // directory holding envoy-server.crt and envoy-server.key
+	XDSClusterName string `yaml:"xds_cluster_name"` // name of the control plane cluster in Envoy bootstraps
+	AuditLog       string `yaml:"audit_log"`        // JSON lines file, defaults to <state_dir>/audit.log
+
+	Lease     Lease     `yaml:"lease"`
+	GRPC      GRPC      `yaml:"grpc"`
+	TLS       TLS       `yaml:"tls"`
+	HTTP      HTTP      `yaml:"http"`
+	Runtime   Runtime   `yaml:"runtime"`
+	AccessLog AccessLog `yaml:"access_log"`
+	Tracing   Tracing   `yaml:"tracing"`
+	Stats     Stats     `yaml:"stats"`
+}
+
+type Lease struct {
+	File     string        `yaml:"file"` // empty runs a single replica
+	ID       string        `yaml:"id"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+/* Structure GRPC:
+ * settings of the xDS management server.
+ */
+type GRPC struct {
+	KeepaliveTime        time.Duration `yaml:"keepalive_time"`
+	KeepaliveTimeout     time.Duration `yaml:"keepalive_timeout"`
+	KeepaliveMinTime     time.Duration `yaml:"keepalive_min_time"`
+	MaxConcurrentStreams uint32        `yaml:"max_concurrent_streams"`
+	ShutdownTimeout      time.Duration `yaml:"shutdown_timeout"` // deadline for open xDS streams to drain
+}
+
+/* Structure TLS:
+ * credentials of the xDS server, plaintext when CertFile is empty. Setting
+ * ClientCAFile requires Envoys to present a certificate issued by that CA.
+ */
+type TLS struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+/* Structure Runtime:
+ * the RTDS layer served to every node. File holds values for all nodes
+ * and per node ID, reloaded when it changes; labels take precedence.
+ */
+type Runtime struct {
+	LayerName string `yaml:"layer_name"` // must match the rtds_layer of the Envoy bootstraps
+	File      string `yaml:"file"`
+}
+
+/* Structure AccessLog:
+ * the access log of the generated listeners, unless a service sets its
+ * own. Sink is empty (none), stdout, file (Path, in Envoy) or grpc, the
+ * access log service of the control plane.
+ */
+type AccessLog struct {
+	Sink    string           `yaml:"sink"`
+	Path    string           `yaml:"path"`
+	Format  string           `yaml:"format"` // Envoy format string, empty for the default format
+	Service AccessLogService `yaml:"service"`
+}
+
+/* Structure AccessLogService:
+ * the gRPC access log service of the control plane. The last Buffer
+ * entries of every service are kept in memory for the admin API, all of
+ * them are appended to File, if set.
+ */
+type AccessLogService struct {
+	Buffer      int    `yaml:"buffer"`
+	File        string `yaml:"file"`
+	MaxFileSize int64  `yaml:"max_file_size"` // bytes before the file is rotated
+	MaxFiles    int    `yaml:"max_files"`     // rotated files kept
+}
+
+/* Structure Tracing:
+ * the spans of the generated listeners, unless a service sets its own.
+ * Provider is empty (none), zipkin or opentelemetry; Collector is the
+ * host[:port] spans are sent to, port 9411 for Zipkin and 4317 for
+ * OpenTelemetry unless given. Tags are literal, or read from a request
+ * header when written "header:<name>".
+ */
+type Tracing struct {
+	Provider        string            `yaml:"provider"`
+	Collector       string            `yaml:"collector"`
+	SamplingPercent float64           `yaml:"sampling_percent"`
+	Tags            map[string]string `yaml:"tags"`
+}
+
+/* Structure Stats:
+ * the stats Envoys stream to the metrics service of the control plane,
+ * which re-exposes them on /metrics of the admin API. Only applies to the
+ * bootstraps generated by the bootstrap command.
+ */
+type Stats struct {
+	Sink          bool          `yaml:"sink"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+/* Structure HTTP:
+ * settings of the HTTP connection managers generated for Envoy.
+ */
+type HTTP struct {
+	IdleTimeout                 time.Duration `yaml:"idle_timeout"`
+	RequestTimeout              time.Duration `yaml:"request_timeout"`
+	MaxConcurrentStreams        uint32        `yaml:"max_concurrent_streams"`
+	InitialStreamWindowSize     uint32        `yaml:"initial_stream_window_size"`
+	InitialConnectionWindowSize uint32        `yaml:"initial_connection_window_size"`
+}
+
+func Default() *Config {
+	return &Config{
+		XDSPort:        18000,
+		AdminPort:      18001,
+		AdminAddress:   "127.0.0.1",
+		IngressNetwork: "mesh-traffic",
+		StateDir:       "state",
+		CertPath:       "deploy/certs",
+		XDSClusterName: "control_plane",
+		Lease: Lease{
+			Duration: 15 * time.Second,
+		},
+		GRPC: GRPC{
+			KeepaliveTime:        30 * time.Second,
+			KeepaliveTimeout:     5 * time.Second,
+			KeepaliveMinTime:     30 * time.Second,
+			MaxConcurrentStreams: 1000000,
+			ShutdownTimeout:      10 * time.Second,
+		},
+		HTTP: HTTP{
+			IdleTimeout:                 1 * time.Hour,
+			RequestTimeout:              5 * time.Minute,
+			MaxConcurrentStreams:        100,
+			InitialStreamWindowSize:     65536,   // 64 KiB
+			InitialConnectionWindowSize: 1048576, // 1 MiB
+		},
+		Runtime: Runtime{
+			LayerName: "rtds",
+		},
+		AccessLog: AccessLog{
+			Path: "/dev/stdout",
+			Service: AccessLogService{
+				Buffer:      1000,
+				MaxFileSize: 100 << 20, // 100 MiB
+				MaxFiles:    5,
+			},
+		},
+		Tracing: Tracing{
+			SamplingPercent: 100,
+		},
+		Stats: Stats{
+			Sink:          true,
+			FlushInterval: 5 * time.Second,
+		},
+	}
+}
+
+/* Function AuditLogPath:
+ * returns where audit entries are appended, empty if nowhere.
+ */
+func (c *Config) AuditLogPath() string {
+	if c.AuditLog != "" || c.StateDir == "" {
+		return c.AuditLog
+	}
+	return filepath.Join(c.StateDir, "audit.log")
+}
+
+/* Function Load:
+ * reads a YAML configuration file on top of the defaults. Unknown keys are
+ * rejected so that typos do not go unnoticed.
+ */
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := Default()
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.XDSPort == 0 || c.XDSPort > 65535 {
+		errs = append(errs, fmt.Errorf("xds_port %d is not a valid port", c.XDSPort))
+	}
+	if c.AdminPort == 0 || c.AdminPort > 65535 {
+		errs = append(errs, fmt.Errorf("admin_port %d is not a valid port", c.AdminPort))
+	}
+	if c.XDSPort == c.AdminPort {
+		errs = append(errs, errors.New("xds_port and admin_port must differ"))
+	}
+	if c.IngressNetwork == "" {
+		errs = append(errs, errors.New("ingress_network is required"))
+	}
+	if c.XDSClusterName == "" {
+		errs = append(errs, errors.New("xds_cluster_name is required"))
+	}
+
+	if c.Lease.File != "" {
+		if c.StateDir == "" {
+			errs = append(errs, errors.New("lease.file requires state_dir shared by all replicas"))
+		}
+		if c.Lease.ID == "" {
+			errs = append(errs, errors.New("lease.id is required with lease.file"))
+		}
+	}
+	if c.Lease.Duration <= 0 {
+		errs = append(errs, errors.New("lease.duration must be positive"))
+	}
+
+	if c.GRPC.KeepaliveTime <= 0 || c.GRPC.KeepaliveTimeout <= 0 || c.GRPC.KeepaliveMinTime <= 0 {
+		errs = append(errs, errors.New("grpc keepalive durations must be positive"))
+	}
+	if c.GRPC.MaxConcurrentStreams == 0 {
+		errs = append(errs, errors.New("grpc.max_concurrent_streams must be positive"))
+	}
+	if c.GRPC.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("grpc.shutdown_timeout must not be negative"))
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls.cert_file and tls.key_file must be set together"))
+	}
+	if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		errs = append(errs, errors.New("tls.client_ca_file requires tls.cert_file and tls.key_file"))
+	}
+
+	if c.HTTP.IdleTimeout < 0 || c.HTTP.RequestTimeout < 0 {
+		errs = append(errs, errors.New("http timeouts must not be negative")) // zero disables them in Envoy
+	}
+	if c.HTTP.MaxConcurrentStreams == 0 {
+		errs = append(errs, errors.New("http.max_concurrent_streams must be positive"))
+	}
+	if w := c.HTTP.InitialStreamWindowSize; w < minHTTP2WindowSize || w > maxHTTP2WindowSize {
+		errs = append(errs, fmt.Errorf("http.initial_stream_window_size %d is outside [%d, %d]", w, minHTTP2WindowSize, maxHTTP2WindowSize))
+	}
+	if w := c.HTTP.InitialConnectionWindowSize; w < minHTTP2WindowSize || w > maxHTTP2WindowSize {
+		errs = append(errs, fmt.Errorf("http.initial_connection_window_size %d is outside [%d, %d]", w, minHTTP2WindowSize, maxHTTP2WindowSize))
+	}
+
+	if c.Runtime.LayerName == "" {
+		errs = append(errs, errors.New("runtime.layer_name is required"))
+	}
+
+	switch c.AccessLog.Sink {
+	case "", "stdout", "grpc":
+	case "file":
+		if c.AccessLog.Path == "" {
+			errs = append(errs, errors.New("access_log.path is required with the file sink"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("access_log.sink %q is not one of stdout, file and grpc", c.AccessLog.Sink))
+	}
+	if c.AccessLog.Service.Buffer < 0 {
+		errs = append(errs, errors.New("access_log.service.buffer must not be negative"))
+	}
+	switch c.Tracing.Provider {
+	case "":
+	case "zipkin", "opentelemetry":
+		if c.Tracing.Collector == "" {
+			errs = append(errs, errors.New("tracing.collector is required with tracing.provider"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tracing.provider %q is not one of zipkin and opentelemetry", c.Tracing.Provider))
+	}
+	if p := c.Tracing.SamplingPercent; p < 0 || p > 100 {
+		errs = append(errs, fmt.Errorf("tracing.sampling_percent %g is outside [0, 100]", p))
+	}
+	if c.Stats.FlushInterval < 0 {
+		errs = append(errs, errors.New("stats.flush_interval must not be negative"))
+	}
+	if c.AccessLog.Service.MaxFileSize < 0 || c.AccessLog.Service.MaxFiles < 0 {
+		errs = append(errs, errors.New("access_log.service rotation limits must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
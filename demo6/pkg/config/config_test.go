@@ -0,0 +1,82 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"envoy-swarm-control/pkg/config"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		modify    func(c *config.Config)
+		succeeded bool
+	}{
+		{"default", func(c *config.Config) {}, true},
+		{"admin port zero", func(c *config.Config) { c.AdminPort = 0 }, false},
+		{"xds port out of range", func(c *config.Config) { c.XDSPort = 70000 }, false},
+		{"same ports", func(c *config.Config) { c.AdminPort = c.XDSPort }, false},
+		{"lease without id", func(c *config.Config) { c.Lease.File = "lease" }, false},
+		{"lease", func(c *config.Config) { c.Lease.File, c.Lease.ID = "lease", "replica-1" }, true},
+		{"tls key without certificate", func(c *config.Config) { c.TLS.KeyFile = "server.key" }, false},
+		{"http window too small", func(c *config.Config) { c.HTTP.InitialStreamWindowSize = 1024 }, false},
+		{"unknown access log sink", func(c *config.Config) { c.AccessLog.Sink = "syslog" }, false},
+		{"negative access log buffer", func(c *config.Config) { c.AccessLog.Service.Buffer = -1 }, false},
+		{"tracing", func(c *config.Config) { c.Tracing.Provider, c.Tracing.Collector = "zipkin", "zipkin:9411" }, true},
+		{"unknown tracing provider", func(c *config.Config) { c.Tracing.Provider, c.Tracing.Collector = "jaeger", "jaeger:14250" }, false},
+		{"tracing without collector", func(c *config.Config) { c.Tracing.Provider = "opentelemetry" }, false},
+		{"sampling above 100", func(c *config.Config) { c.Tracing.SamplingPercent = 101 }, false},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := config.Default()
+			test.modify(c)
+			if err := c.Validate(); (err == nil) != test.succeeded {
+				t.Errorf("Config.Validate() error: %v, succeeded: %v", err, test.succeeded)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		content   string
+		succeeded bool
+	}{
+		{"empty", "", true},
+		{"override", "xds_port: 19000\ntracing:\n  provider: zipkin\n  collector: zipkin:9411\n", true},
+		{"unknown key", "xds_prot: 19000\n", false},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(test.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			c, err := config.Load(path)
+			if (err == nil) != test.succeeded {
+				t.Fatalf("Load() error: %v, succeeded: %v", err, test.succeeded)
+			}
+			if err == nil {
+				if err := c.Validate(); err != nil {
+					t.Errorf("Config.Validate() error: %v", err)
+				}
+			}
+		})
+	}
+}
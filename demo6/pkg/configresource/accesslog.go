@@ -0,0 +1,125 @@
+package configresource
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	accesslog "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	file "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
+	grpc "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/grpc/v3"
+	stream "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/stream/v3"
+	resource "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+)
+
+// Access log sinks
+const (
+	AccessLogNone   = "none"
+	AccessLogStdout = "stdout"
+	AccessLogFile   = "file"
+	AccessLogGRPC   = "grpc" // the access log service of the control plane
+)
+
+/* Structure AccessLog:
+ * where a generated listener logs its requests. Format is an Envoy
+ * format string, empty for the default format of Envoy; it does not
+ * apply to the gRPC sink, whose entries are structured.
+ */
+type AccessLog struct {
+	Sink   string
+	Path   string
+	Format string
+}
+
+// Access log of the services without envoy.accesslog labels, overridden by the control plane configuration file
+var DefaultAccessLog = AccessLog{Path: "/dev/stdout"}
+
+/* Function Merge:
+ * returns the access log with the fields set in override replacing its
+ * own.
+ */
+func (a AccessLog) Merge(override AccessLog) AccessLog {
+	if override.Sink != "" {
+		a.Sink = override.Sink
+	}
+	if override.Path != "" {
+		a.Path = override.Path
+	}
+	if override.Format != "" {
+		a.Format = override.Format
+	}
+	return a
+}
+
+/* Function ProvideAccessLogs:
+ * returns the access logs of an HTTP connection manager, none for an
+ * empty or "none" sink. The gRPC sink streams to the xDS cluster under
+ * logName, which the access log service of the control plane groups its
+ * entries by.
+ */
+func ProvideAccessLogs(logName string, a AccessLog) ([]*accesslog.AccessLog, error) {
+	var (
+		name   string
+		config proto.Message
+	)
+	switch a.Sink {
+	case "", AccessLogNone:
+		return nil, nil
+	case AccessLogStdout:
+		out := &stream.StdoutAccessLog{}
+		if a.Format != "" {
+			out.AccessLogFormat = &stream.StdoutAccessLog_LogFormat{LogFormat: textFormat(a.Format)}
+		}
+		name, config = "envoy.access_loggers.stdout", out
+	case AccessLogFile:
+		out := &file.FileAccessLog{Path: a.Path}
+		if a.Format != "" {
+			out.AccessLogFormat = &file.FileAccessLog_LogFormat{LogFormat: textFormat(a.Format)}
+		}
+		name, config = wellknown.FileAccessLog, out
+	case AccessLogGRPC:
+		name, config = wellknown.HTTPGRPCAccessLog, &grpc.HttpGrpcAccessLogConfig{
+			CommonConfig: &grpc.CommonGrpcAccessLogConfig{
+				LogName: logName,
+				GrpcService: &core.GrpcService{
+					TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+						EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: XDSClusterName},
+					},
+				},
+				TransportApiVersion: resource.DefaultAPIVersion,
+			},
+		}
+	default:
+		return nil, fmt.Errorf("unknown access log sink %q", a.Sink)
+	}
+	logrus.Infof(">>>>>>>>>>>>>>>>>>> creating %s access log %s", a.Sink, logName)
+
+	typedConfig, err := messageToAnyWithError(config)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling access log %s: %w", logName, err)
+	}
+	return []*accesslog.AccessLog{{
+		Name:       name,
+		ConfigType: &accesslog.AccessLog_TypedConfig{TypedConfig: typedConfig},
+	}}, nil
+}
+
+/* Function textFormat:
+ * a plain text format string, one line per request.
+ */
+func textFormat(format string) *core.SubstitutionFormatString {
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+	return &core.SubstitutionFormatString{
+		Format: &core.SubstitutionFormatString_TextFormatSource{
+			TextFormatSource: &core.DataSource{
+				Specifier: &core.DataSource_InlineString{InlineString: format},
+			},
+		},
+	}
+}
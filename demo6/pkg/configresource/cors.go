@@ -0,0 +1,53 @@
+package configresource
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	cors "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/cors/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+)
+
+/* Function ProvideCorsFilter:
+ * returns the CORS filter of a listener, the policies being set per
+ * virtual host.
+ */
+func ProvideCorsFilter(filterName string) HTTPFilter {
+	return HTTPFilter{Name: filterName, Config: &cors.Cors{}}
+}
+
+/* Function ProvideCorsPolicy:
+ * returns the CORS policy of a virtual host. Origins are matched exactly,
+ * ignoring case, "*" allowing any origin; the other lists are given as
+ * comma-separated values of the corresponding Access-Control headers.
+ */
+func ProvideCorsPolicy(allowOrigins []string, allowMethods, allowHeaders, exposeHeaders string, maxAge time.Duration, allowCredentials bool) *cors.CorsPolicy {
+	origins := make([]*matcher.StringMatcher, 0, len(allowOrigins))
+	for _, origin := range allowOrigins {
+		if origin == "*" {
+			origins = append(origins, &matcher.StringMatcher{
+				MatchPattern: &matcher.StringMatcher_SafeRegex{SafeRegex: &matcher.RegexMatcher{Regex: ".*"}},
+			})
+			continue
+		}
+		origins = append(origins, &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Exact{Exact: origin},
+			IgnoreCase:   true,
+		})
+	}
+
+	policy := &cors.CorsPolicy{
+		AllowOriginStringMatch: origins,
+		AllowMethods:           strings.ToUpper(allowMethods),
+		AllowHeaders:           allowHeaders,
+		ExposeHeaders:          exposeHeaders,
+		AllowCredentials:       wrapperspb.Bool(allowCredentials),
+	}
+	if maxAge > 0 {
+		policy.MaxAge = strconv.FormatInt(int64(maxAge/time.Second), 10)
+	}
+	return policy
+}
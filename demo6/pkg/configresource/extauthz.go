@@ -0,0 +1,75 @@
+package configresource
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extauthz "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_authz/v3"
+	upstreamhttp "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	resource "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+/* Function ProvideGRPCCluster:
+ * returns a cluster speaking HTTP/2 to its upstream, as required by gRPC
+ * services such as the authorization server of demo5.
+ */
+func ProvideGRPCCluster(clusterName, upstreamHost string, upstreamPort uint32) (*cluster.Cluster, error) {
+	options, err := anypb.New(&upstreamhttp.HttpProtocolOptions{
+		UpstreamProtocolOptions: &upstreamhttp.HttpProtocolOptions_ExplicitHttpConfig_{
+			ExplicitHttpConfig: &upstreamhttp.HttpProtocolOptions_ExplicitHttpConfig{
+				ProtocolConfig: &upstreamhttp.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{
+					Http2ProtocolOptions: &core.Http2ProtocolOptions{},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling protocol options of %s: %w", clusterName, err)
+	}
+
+	c := ProvideCluster(clusterName, upstreamHost, upstreamPort)
+	c.TypedExtensionProtocolOptions = map[string]*anypb.Any{
+		"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": options,
+	}
+	return c, nil
+}
+
+/* Function ProvideExtAuthzFilter:
+ * returns the external authorization filter checking every request with
+ * the gRPC authorization service of the given cluster. Without a reply
+ * within timeout, or on error, requests are denied unless
+ * failureModeAllow is set. A zero timeout keeps Envoy's default.
+ */
+func ProvideExtAuthzFilter(filterName, clusterName string, timeout time.Duration, failureModeAllow bool) HTTPFilter {
+	service := &core.GrpcService{
+		TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+			EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: clusterName},
+		},
+	}
+	if timeout > 0 {
+		service.Timeout = durationpb.New(timeout)
+	}
+
+	return HTTPFilter{
+		Name: filterName,
+		Config: &extauthz.ExtAuthz{
+			TransportApiVersion: resource.DefaultAPIVersion,
+			Services:            &extauthz.ExtAuthz_GrpcService{GrpcService: service},
+			FailureModeAllow:    failureModeAllow,
+		},
+	}
+}
+
+/* Function ProvideExtAuthzDisabled:
+ * returns the per-route configuration skipping authorization.
+ */
+func ProvideExtAuthzDisabled() *extauthz.ExtAuthzPerRoute {
+	return &extauthz.ExtAuthzPerRoute{
+		Override: &extauthz.ExtAuthzPerRoute_Disabled{Disabled: true},
+	}
+}
@@ -0,0 +1,58 @@
+package configresource
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+)
+
+/* Structure HTTPFilter:
+ * an HTTP filter of a generated listener whose configuration is served
+ * over ECDS. The listener only references the filter by name, so that a
+ * change to its configuration is pushed without re-creating the listener
+ * and draining its connections.
+ */
+type HTTPFilter struct {
+	Name   string        // name of the filter in the chain and of its ECDS resource, e.g., "local_node_1_fault"
+	Config proto.Message // typed configuration of the filter
+}
+
+func (f HTTPFilter) typeURL() string {
+	return "type.googleapis.com/" + string(f.Config.ProtoReflect().Descriptor().FullName())
+}
+
+/* Function ProvideExtensionConfig:
+ * returns the ECDS resource holding the configuration of a filter.
+ */
+func ProvideExtensionConfig(f HTTPFilter) (*core.TypedExtensionConfig, error) {
+	logrus.Infof(">>>>>>>>>>>>>>>>>>> creating extension config %s", f.Name)
+
+	config, err := messageToAnyWithError(f.Config)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling extension config %s: %w", f.Name, err)
+	}
+	return &core.TypedExtensionConfig{
+		Name:        f.Name,
+		TypedConfig: config,
+	}, nil
+}
+
+/* Function discoveredHTTPFilter:
+ * the entry of a filter in the HTTP filter chain, fetched over the same
+ * config source as the route configuration.
+ */
+func discoveredHTTPFilter(f HTTPFilter) *hcm.HttpFilter {
+	return &hcm.HttpFilter{
+		Name: f.Name,
+		ConfigType: &hcm.HttpFilter_ConfigDiscovery{
+			ConfigDiscovery: &core.ExtensionConfigSource{
+				ConfigSource: makeConfigSource(),
+				TypeUrls:     []string{f.typeURL()},
+			},
+		},
+	}
+}
@@ -0,0 +1,39 @@
+package configresource
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	faultcommon "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/common/fault/v3"
+	fault "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+)
+
+/* Function ProvideFaultFilter:
+ * returns the fault injection filter delaying and/or aborting the given
+ * percentages of requests. A zero delay or abort status disables either.
+ */
+func ProvideFaultFilter(filterName string, delay time.Duration, delayPercent, abortStatus, abortPercent uint32) HTTPFilter {
+	config := &fault.HTTPFault{}
+	if delay > 0 {
+		config.Delay = &faultcommon.FaultDelay{
+			FaultDelaySecifier: &faultcommon.FaultDelay_FixedDelay{FixedDelay: durationpb.New(delay)},
+			Percentage:         percent(delayPercent),
+		}
+	}
+	if abortStatus > 0 {
+		config.Abort = &fault.FaultAbort{
+			ErrorType:  &fault.FaultAbort_HttpStatus{HttpStatus: abortStatus},
+			Percentage: percent(abortPercent),
+		}
+	}
+	return HTTPFilter{Name: filterName, Config: config}
+}
+
+func percent(p uint32) *typev3.FractionalPercent {
+	return &typev3.FractionalPercent{
+		Numerator:   p,
+		Denominator: typev3.FractionalPercent_HUNDRED,
+	}
+}
@@ -6,11 +6,11 @@ import (
 
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/sirupsen/logrus"
-	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
 
+	accesslog "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	router "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
@@ -19,16 +19,42 @@ import (
 	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
 )
 
-const (
-	HTTPIdleTimeout                            = 1 * time.Hour
-	RequestTimeout                             = 5 * time.Minute
-	MaxConcurrentHTTP2Streams                  = 100
-	InitialDownstreamHTTP2StreamWindowSize     = 65536   // 64 KiB
-	InitialDownstreamHTTP2ConnectionWindowSize = 1048576 // 1 MiB
+// Defaults, overridden by the control plane configuration file
+var (
+	HTTPIdleTimeout                                   = 1 * time.Hour
+	RequestTimeout                                    = 5 * time.Minute
+	MaxConcurrentHTTP2Streams                  uint32 = 100
+	InitialDownstreamHTTP2StreamWindowSize     uint32 = 65536           // 64 KiB
+	InitialDownstreamHTTP2ConnectionWindowSize uint32 = 1048576         // 1 MiB
+	XDSClusterName                                    = "control_plane" // xDS cluster of the Envoy bootstrap
 )
 
-func ProvideHTTPListener(listenerName, routeConfigName string, listenerPort uint32) *listener.Listener {
-	logrus.Infof(">>>>>>>>>>>>>>>>>>> creating listener with listenerName " + listenerName)
+// Transport Envoy uses to fetch RDS and ECDS, switched to DELTA_GRPC when serving incremental xDS
+var XDSAPIType = core.ApiConfigSource_GRPC
+
+/* Function ProvideHTTPListener:
+ * returns a listener routing over RDS, running the given filters, served
+ * over ECDS, in order before the router, logging its requests to the
+ * given access logs and tracing them, if tracing is not nil.
+ */
+func ProvideHTTPListener(listenerName, routeConfigName string, listenerPort uint32, filters []HTTPFilter, accessLogs []*accesslog.AccessLog, tracing *hcm.HttpConnectionManager_Tracing) (*listener.Listener, error) {
+	logrus.Infof(">>>>>>>>>>>>>>>>>>> creating listener with listenerName %s", listenerName)
+
+	routerConfig, err := messageToAnyWithError(&router.Router{})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling router filter of %s: %w", listenerName, err)
+	}
+
+	httpFilters := make([]*hcm.HttpFilter, 0, len(filters)+1)
+	for _, f := range filters {
+		httpFilters = append(httpFilters, discoveredHTTPFilter(f))
+	}
+	httpFilters = append(httpFilters, &hcm.HttpFilter{
+		Name: wellknown.Router,
+		ConfigType: &hcm.HttpFilter_TypedConfig{
+			TypedConfig: routerConfig,
+		},
+	})
 
 	manager := &hcm.HttpConnectionManager{
 		CodecType:  hcm.HttpConnectionManager_AUTO,
@@ -39,20 +65,17 @@ func ProvideHTTPListener(listenerName, routeConfigName string, listenerPort uint
 				ConfigSource:    makeConfigSource(),
 			},
 		},
-		HttpFilters: []*hcm.HttpFilter{{
-			Name: wellknown.Router,
-			ConfigType: &hcm.HttpFilter_TypedConfig{
-				TypedConfig: messageToAny(&router.Router{}),
-			},
-		}},
+		HttpFilters: httpFilters,
+		AccessLog:   accessLogs,
+		Tracing:     tracing,
 		CommonHttpProtocolOptions: &core.HttpProtocolOptions{
 			IdleTimeout:                  durationpb.New(HTTPIdleTimeout),
 			HeadersWithUnderscoresAction: core.HttpProtocolOptions_REJECT_REQUEST,
 		},
 		Http2ProtocolOptions: &core.Http2ProtocolOptions{
-			MaxConcurrentStreams:        &wrappers.UInt32Value{Value: uint32(MaxConcurrentHTTP2Streams)},
-			InitialStreamWindowSize:     &wrappers.UInt32Value{Value: uint32(InitialDownstreamHTTP2StreamWindowSize)},
-			InitialConnectionWindowSize: &wrappers.UInt32Value{Value: uint32(InitialDownstreamHTTP2ConnectionWindowSize)},
+			MaxConcurrentStreams:        &wrappers.UInt32Value{Value: MaxConcurrentHTTP2Streams},
+			InitialStreamWindowSize:     &wrappers.UInt32Value{Value: InitialDownstreamHTTP2StreamWindowSize},
+			InitialConnectionWindowSize: &wrappers.UInt32Value{Value: InitialDownstreamHTTP2ConnectionWindowSize},
 		},
 		StreamIdleTimeout: durationpb.New(RequestTimeout),
 		RequestTimeout:    durationpb.New(RequestTimeout),
@@ -60,7 +83,7 @@ func ProvideHTTPListener(listenerName, routeConfigName string, listenerPort uint
 
 	pbst, err := anypb.New(manager)
 	if err != nil {
-		logrus.Fatal(err)
+		return nil, fmt.Errorf("marshaling HTTP connection manager of %s: %w", listenerName, err)
 	}
 
 	/*
@@ -79,7 +102,7 @@ func ProvideHTTPListener(listenerName, routeConfigName string, listenerPort uint
 
 		scfg, err := anypb.New(sdsTls)
 		if err != nil {
-			logrus.Fatal(err)
+			return nil, err
 		}
 	*/
 
@@ -112,7 +135,7 @@ func ProvideHTTPListener(listenerName, routeConfigName string, listenerPort uint
 				},
 			*/
 		}},
-	}
+	}, nil
 }
 
 func makeConfigSource() *core.ConfigSource {
@@ -121,11 +144,11 @@ func makeConfigSource() *core.ConfigSource {
 	source.ConfigSourceSpecifier = &core.ConfigSource_ApiConfigSource{
 		ApiConfigSource: &core.ApiConfigSource{
 			TransportApiVersion:       resource.DefaultAPIVersion,
-			ApiType:                   core.ApiConfigSource_GRPC,
+			ApiType:                   XDSAPIType,
 			SetNodeOnFirstMessageOnly: true,
 			GrpcServices: []*core.GrpcService{{
 				TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
-					EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: "control_plane"}, // xDS cluster
+					EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: XDSClusterName},
 				},
 			}},
 		},
@@ -143,12 +166,3 @@ func messageToAnyWithError(msg proto.Message) (*anypb.Any, error) {
 		Value:   b,
 	}, nil
 }
-
-func messageToAny(msg proto.Message) *anypb.Any {
-	out, err := messageToAnyWithError(msg)
-	if err != nil {
-		logrus.Error(fmt.Sprintf("Error marshaling Any %s: %v", prototext.Format(msg), err))
-		return nil
-	}
-	return out
-}
@@ -0,0 +1,76 @@
+package configresource
+
+import (
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	localratelimit "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+)
+
+// Runtime keys turning the local rate limit on or off, and into shadow mode, over RTDS
+const (
+	localRateLimitEnabledKey  = "local_rate_limit_enabled"
+	localRateLimitEnforcedKey = "local_rate_limit_enforced"
+)
+
+/* Function ProvideLocalRateLimitFilter:
+ * returns the local rate limit filter of a listener. It does not limit
+ * anything by itself, the token buckets are set per route.
+ */
+func ProvideLocalRateLimitFilter(filterName string) HTTPFilter {
+	return HTTPFilter{
+		Name:   filterName,
+		Config: &localratelimit.LocalRateLimit{StatPrefix: "http_local_rate_limiter"},
+	}
+}
+
+/* Function ProvideLocalRateLimit:
+ * returns the per-route configuration of the local rate limit filter:
+ * requests are refilled every unit up to burst, requests beyond that are
+ * answered with 429 and the given response headers.
+ */
+func ProvideLocalRateLimit(requestsPerUnit uint32, unit time.Duration, burst uint32, responseHeaders map[string]string) *localratelimit.LocalRateLimit {
+	return &localratelimit.LocalRateLimit{
+		StatPrefix: "http_local_rate_limiter",
+		TokenBucket: &typev3.TokenBucket{
+			MaxTokens:     burst,
+			TokensPerFill: wrapperspb.UInt32(requestsPerUnit),
+			FillInterval:  durationpb.New(unit),
+		},
+		FilterEnabled:        runtimePercent(localRateLimitEnabledKey, 100),
+		FilterEnforced:       runtimePercent(localRateLimitEnforcedKey, 100),
+		ResponseHeadersToAdd: headerValues(responseHeaders, core.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD),
+	}
+}
+
+func runtimePercent(runtimeKey string, p uint32) *core.RuntimeFractionalPercent {
+	return &core.RuntimeFractionalPercent{
+		DefaultValue: percent(p),
+		RuntimeKey:   runtimeKey,
+	}
+}
+
+/* Function headerValues:
+ * converts headers to options sorted by name, for deterministic versions.
+ */
+func headerValues(headers map[string]string, action core.HeaderValueOption_HeaderAppendAction) []*core.HeaderValueOption {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]*core.HeaderValueOption, 0, len(names))
+	for _, name := range names {
+		out = append(out, &core.HeaderValueOption{
+			Header:       &core.HeaderValue{Key: name, Value: headers[name]},
+			AppendAction: action,
+		})
+	}
+	return out
+}
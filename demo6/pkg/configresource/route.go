@@ -1,14 +1,59 @@
 package configresource
 
 import (
+	"fmt"
 	"time"
 
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
 
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 )
 
-func ProvideRoute(routeConfigName, virtualHostName, clusterName, upstreamHost, pathPrefix string, requestTimeout time.Duration) *route.RouteConfiguration {
+/* Structure HeaderMutation:
+ * headers added to (Add), overwritten in (Set) or removed from (Remove)
+ * requests or responses. Values may hold Envoy variables such as
+ * %DOWNSTREAM_REMOTE_ADDRESS%, a literal % being written %%.
+ */
+type HeaderMutation struct {
+	Add    map[string]string
+	Set    map[string]string
+	Remove []string
+}
+
+func (h HeaderMutation) options() []*core.HeaderValueOption {
+	return append(
+		headerValues(h.Add, core.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD),
+		headerValues(h.Set, core.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD)...,
+	)
+}
+
+/* Structure PerFilterConfig:
+ * configurations of HTTP filters specific to the virtual host or the
+ * route of a service, keyed by filter name.
+ */
+type PerFilterConfig struct {
+	VirtualHost map[string]proto.Message
+	Route       map[string]proto.Message
+}
+
+/* Function ProvideRoute:
+ * returns the route configuration of a service, with the header changes
+ * and filter configurations of its route.
+ */
+func ProvideRoute(routeConfigName, virtualHostName, clusterName, upstreamHost, pathPrefix string, requestTimeout time.Duration,
+	requestHeaders, responseHeaders HeaderMutation, perFilterConfig PerFilterConfig) (*route.RouteConfiguration, error) {
+	virtualHostConfig, err := typedPerFilterConfig(routeConfigName, perFilterConfig.VirtualHost)
+	if err != nil {
+		return nil, err
+	}
+	routeConfig, err := typedPerFilterConfig(routeConfigName, perFilterConfig.Route)
+	if err != nil {
+		return nil, err
+	}
+
 	return &route.RouteConfiguration{
 		Name: routeConfigName, // e.g., "local_route"
 		VirtualHosts: []*route.VirtualHost{{
@@ -34,7 +79,60 @@ func ProvideRoute(routeConfigName, virtualHostName, clusterName, upstreamHost, p
 						Timeout:     durationpb.New(requestTimeout),
 					},
 				},
+				RequestHeadersToAdd:     requestHeaders.options(),
+				RequestHeadersToRemove:  requestHeaders.Remove,
+				ResponseHeadersToAdd:    responseHeaders.options(),
+				ResponseHeadersToRemove: responseHeaders.Remove,
+				TypedPerFilterConfig:    routeConfig,
 			}},
+			TypedPerFilterConfig: virtualHostConfig,
 		}},
+	}, nil
+}
+
+func typedPerFilterConfig(routeConfigName string, configs map[string]proto.Message) (map[string]*anypb.Any, error) {
+	out := make(map[string]*anypb.Any, len(configs))
+	for name, config := range configs {
+		a, err := messageToAnyWithError(config)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling %s configuration of %s: %w", name, routeConfigName, err)
+		}
+		out[name] = a
+	}
+	return out, nil
+}
+
+/* Function AddPathOverrides:
+ * prepends to every virtual host a copy of its first route for each path,
+ * with perFilterConfig replacing the route's filter configurations of the
+ * same name, e.g., to disable a filter on some paths. A path matches
+ * itself and the paths below it, i.e., /public does not match /publicity.
+ */
+func AddPathOverrides(rc *route.RouteConfiguration, paths []string, perFilterConfig map[string]proto.Message) error {
+	overrides, err := typedPerFilterConfig(rc.GetName(), perFilterConfig)
+	if err != nil {
+		return err
+	}
+
+	for _, vh := range rc.GetVirtualHosts() {
+		if len(vh.GetRoutes()) == 0 {
+			continue
+		}
+		routes := make([]*route.Route, 0, len(paths)+len(vh.GetRoutes()))
+		for _, path := range paths {
+			r := proto.Clone(vh.GetRoutes()[0]).(*route.Route)
+			r.Match = &route.RouteMatch{
+				PathSpecifier: &route.RouteMatch_PathSeparatedPrefix{PathSeparatedPrefix: path},
+			}
+			if r.TypedPerFilterConfig == nil {
+				r.TypedPerFilterConfig = make(map[string]*anypb.Any, len(overrides))
+			}
+			for name, config := range overrides {
+				r.TypedPerFilterConfig[name] = config
+			}
+			routes = append(routes, r)
+		}
+		vh.Routes = append(routes, vh.GetRoutes()...)
 	}
+	return nil
 }
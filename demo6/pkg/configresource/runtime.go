@@ -0,0 +1,31 @@
+package configresource
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	runtime "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+)
+
+// Name of the RTDS layer, as referenced by the rtds_layer of the Envoy bootstrap
+var RuntimeLayerName = "rtds"
+
+/* Function ProvideRuntime:
+ * returns the RTDS layer of a node. Nested maps are flattened by Envoy,
+ * {"a": {"b": 1}} being the same as {"a.b": 1}. The layer is served even
+ * when empty, as Envoy waits for it during initialization.
+ */
+func ProvideRuntime(values map[string]interface{}) (*runtime.Runtime, error) {
+	logrus.Infof(">>>>>>>>>>>>>>>>>>> creating runtime layer %s with %d key(s)", RuntimeLayerName, len(values))
+
+	layer, err := structpb.NewStruct(values)
+	if err != nil {
+		return nil, fmt.Errorf("runtime layer %s: %w", RuntimeLayerName, err)
+	}
+	return &runtime.Runtime{
+		Name:  RuntimeLayerName,
+		Layer: layer,
+	}, nil
+}
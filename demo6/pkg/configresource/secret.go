@@ -1,6 +1,8 @@
 package configresource
 
 import (
+	"path/filepath"
+
 	util "envoy-swarm-control/pkg/utils"
 
 	"github.com/sirupsen/logrus"
@@ -9,17 +11,14 @@ import (
 	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 )
 
-const (
-	secretName = "server_cert"
-	certPath   = "/Users/xuanyuanxingjian/Documents/projects/Repos/github-envoy-playground/demo6/deploy/certs"
-)
+const secretName = "server_cert"
 
-var (
-	envoyServerCert = certPath + "/envoy-server.crt"
-	envoyServerKey  = certPath + "/envoy-server.key"
-)
+// Directory holding envoy-server.crt and envoy-server.key
+var CertPath = "deploy/certs"
 
 func ProvideSecret() *auth.Secret {
+	envoyServerCert := filepath.Join(CertPath, "envoy-server.crt")
+	envoyServerKey := filepath.Join(CertPath, "envoy-server.key")
 	logrus.Infof(">>>>>>>>>>>>>>>>>>> creating secret with secretName " + secretName)
 
 	s := &auth.Secret{
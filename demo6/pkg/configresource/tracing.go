@@ -0,0 +1,192 @@
+package configresource
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	trace "github.com/envoyproxy/go-control-plane/envoy/config/trace/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tracingtype "github.com/envoyproxy/go-control-plane/envoy/type/tracing/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+)
+
+// Tracing providers
+const (
+	TracingZipkin        = "zipkin"
+	TracingOpenTelemetry = "opentelemetry"
+	TracingNone          = "none" // turns off the tracing a service would otherwise inherit
+)
+
+// Ports of the collectors when not given
+const (
+	defaultZipkinPort        = 9411
+	defaultOpenTelemetryPort = 4317 // OTLP over gRPC
+)
+
+// Prefix of a custom tag value read from a request header rather than literal
+const TracingHeaderTagPrefix = "header:"
+
+/* Structure Tracing:
+ * the spans a generated listener emits, enabled by Provider. Tags are
+ * added to every span, either literally or, for values of the form
+ * "header:<name>", from a request header. SamplingPercent defaults to
+ * every request when not set.
+ */
+type Tracing struct {
+	Provider        string
+	CollectorHost   string
+	CollectorPort   uint32
+	SamplingPercent *float64          `json:",omitempty"`
+	Tags            map[string]string `json:",omitempty"`
+}
+
+// Tracing of the services without envoy.tracing labels, overridden by the control plane configuration file
+var DefaultTracing = Tracing{}
+
+/* Function Merge:
+ * returns the tracing with the fields set in override replacing its own.
+ * Tags are merged, those of override winning. Provider none turns tracing
+ * off altogether.
+ */
+func (t Tracing) Merge(override Tracing) Tracing {
+	if override.Provider == TracingNone {
+		return Tracing{}
+	}
+	if override.Provider != "" {
+		t.Provider = override.Provider
+	}
+	if override.CollectorHost != "" {
+		t.CollectorHost, t.CollectorPort = override.CollectorHost, override.CollectorPort
+	}
+	if override.SamplingPercent != nil {
+		t.SamplingPercent = override.SamplingPercent
+	}
+	if len(override.Tags) > 0 {
+		tags := make(map[string]string, len(t.Tags)+len(override.Tags))
+		for name, value := range t.Tags {
+			tags[name] = value
+		}
+		for name, value := range override.Tags {
+			tags[name] = value
+		}
+		t.Tags = tags
+	}
+	return t
+}
+
+func (t Tracing) Enabled() bool {
+	return t.Provider != "" && t.Provider != TracingNone
+}
+
+func (t Tracing) samplingPercent() float64 {
+	if t.SamplingPercent == nil {
+		return 100
+	}
+	return *t.SamplingPercent
+}
+
+func (t Tracing) collectorPort() uint32 {
+	switch {
+	case t.CollectorPort != 0:
+		return t.CollectorPort
+	case t.Provider == TracingOpenTelemetry:
+		return defaultOpenTelemetryPort
+	}
+	return defaultZipkinPort
+}
+
+/* Function ProvideTracingCluster:
+ * returns the cluster of the collector spans are sent to: Zipkin over
+ * HTTP, OpenTelemetry over gRPC.
+ */
+func ProvideTracingCluster(clusterName string, t Tracing) (*cluster.Cluster, error) {
+	if t.CollectorHost == "" {
+		return nil, fmt.Errorf("%s tracing of %s has no collector", t.Provider, clusterName)
+	}
+	if t.Provider == TracingOpenTelemetry {
+		return ProvideGRPCCluster(clusterName, t.CollectorHost, t.collectorPort())
+	}
+	return ProvideCluster(clusterName, t.CollectorHost, t.collectorPort()), nil
+}
+
+/* Function ProvideTracing:
+ * returns the tracing of an HTTP connection manager, reporting spans
+ * under serviceName to the collector of the given cluster. Zipkin spans
+ * share the B3 context propagated by the services of demo1.
+ */
+func ProvideTracing(serviceName, clusterName string, t Tracing) (*hcm.HttpConnectionManager_Tracing, error) {
+	logrus.Infof(">>>>>>>>>>>>>>>>>>> creating %s tracing of %s", t.Provider, serviceName)
+
+	var (
+		name   string
+		config proto.Message
+	)
+	switch t.Provider {
+	case TracingZipkin:
+		name, config = "envoy.tracers.zipkin", &trace.ZipkinConfig{
+			CollectorCluster:         clusterName,
+			CollectorEndpoint:        "/api/v2/spans",
+			CollectorEndpointVersion: trace.ZipkinConfig_HTTP_JSON,
+			CollectorHostname:        t.CollectorHost,
+			TraceId_128Bit:           true,
+		}
+	case TracingOpenTelemetry:
+		name, config = "envoy.tracers.opentelemetry", &trace.OpenTelemetryConfig{
+			GrpcService: &core.GrpcService{
+				TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+					EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: clusterName},
+				},
+			},
+			ServiceName: serviceName,
+		}
+	default:
+		return nil, fmt.Errorf("unknown tracing provider %q", t.Provider)
+	}
+
+	typedConfig, err := messageToAnyWithError(config)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling tracing of %s: %w", serviceName, err)
+	}
+	return &hcm.HttpConnectionManager_Tracing{
+		RandomSampling: &typev3.Percent{Value: t.samplingPercent()},
+		CustomTags:     customTags(t.Tags),
+		Provider: &trace.Tracing_Http{
+			Name:       name,
+			ConfigType: &trace.Tracing_Http_TypedConfig{TypedConfig: typedConfig},
+		},
+	}, nil
+}
+
+/* Function customTags:
+ * returns the tags sorted by name, so that the listener version only
+ * changes with them.
+ */
+func customTags(tags map[string]string) []*tracingtype.CustomTag {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]*tracingtype.CustomTag, 0, len(names))
+	for _, name := range names {
+		tag := &tracingtype.CustomTag{Tag: name}
+		if header, ok := strings.CutPrefix(tags[name], TracingHeaderTagPrefix); ok {
+			tag.Type = &tracingtype.CustomTag_RequestHeader{
+				RequestHeader: &tracingtype.CustomTag_Header{Name: header},
+			}
+		} else {
+			tag.Type = &tracingtype.CustomTag_Literal_{
+				Literal: &tracingtype.CustomTag_Literal{Value: tags[name]},
+			}
+		}
+		out = append(out, tag)
+	}
+	return out
+}
@@ -0,0 +1,45 @@
+package election
+
+import (
+	"context"
+)
+
+/* Structure Callbacks:
+ * hooks run on leadership transitions. OnStartedLeading gets a context
+ * that is cancelled as soon as leadership is lost; OnStoppedLeading is only
+ * called once OnStartedLeading has returned.
+ */
+type Callbacks struct {
+	OnStartedLeading func(ctx context.Context)
+	OnStoppedLeading func()
+}
+
+/* Interface Elector:
+ * decides which control plane replica acts on swarm events and writes
+ * snapshots. Run blocks until ctx is cancelled.
+ */
+type Elector interface {
+	Run(ctx context.Context, cb Callbacks)
+	IsLeader() bool
+}
+
+/* Structure AlwaysLeader:
+ * the Elector of a single replica deployment.
+ */
+type AlwaysLeader struct{}
+
+var _ Elector = AlwaysLeader{}
+
+func (AlwaysLeader) Run(ctx context.Context, cb Callbacks) {
+	if cb.OnStartedLeading != nil {
+		cb.OnStartedLeading(ctx)
+	}
+	<-ctx.Done()
+	if cb.OnStoppedLeading != nil {
+		cb.OnStoppedLeading()
+	}
+}
+
+func (AlwaysLeader) IsLeader() bool {
+	return true
+}
@@ -0,0 +1,227 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/* Structure FileLease:
+ * an Elector for replicas sharing a host (or a shared volume). The lease
+ * file names its holder and when it was last renewed; every access is
+ * serialised with flock. A standby takes over once the lease has not been
+ * renewed for LeaseDuration, i.e., within LeaseDuration + RetryPeriod of
+ * the leader disappearing. The leader steps down once it has not renewed
+ * the lease for RenewDeadline, so it has stopped by the time a standby
+ * may take over.
+ */
+type FileLease struct {
+	Path          string
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration // must be below LeaseDuration - RenewPeriod
+	RenewPeriod   time.Duration
+	RetryPeriod   time.Duration
+
+	leader atomic.Bool
+}
+
+var _ Elector = &FileLease{}
+
+type leaseRecord struct {
+	Holder    string    `json:"holder"`
+	RenewedAt time.Time `json:"renewed_at"`
+}
+
+func NewFileLease(path, identity string, leaseDuration time.Duration) *FileLease {
+	renewPeriod := leaseDuration / 5
+	return &FileLease{
+		Path:          path,
+		Identity:      identity,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: leaseDuration - 2*renewPeriod,
+		RenewPeriod:   renewPeriod,
+		RetryPeriod:   leaseDuration / 5,
+	}
+}
+
+func (l *FileLease) IsLeader() bool {
+	return l.leader.Load()
+}
+
+func (l *FileLease) Run(ctx context.Context, cb Callbacks) {
+	for {
+		acquiredAt, ok := l.waitForLease(ctx)
+		if !ok {
+			return
+		}
+
+		logrus.Infof("%s acquired the lease %s", l.Identity, l.Path)
+		l.leader.Store(true)
+		leaderCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if cb.OnStartedLeading != nil {
+				cb.OnStartedLeading(leaderCtx)
+			}
+		}()
+
+		l.renewUntilLost(leaderCtx, acquiredAt)
+		l.leader.Store(false)
+		cancel()
+		<-done // a lease still held is kept until the leader's work has stopped
+		if cb.OnStoppedLeading != nil {
+			cb.OnStoppedLeading()
+		}
+
+		if ctx.Err() != nil {
+			l.release()
+			return
+		}
+		logrus.Warnf("%s lost the lease %s", l.Identity, l.Path)
+	}
+}
+
+/* Function waitForLease:
+ * polls until the lease is acquired or ctx is cancelled, returning when
+ * the successful attempt started.
+ */
+func (l *FileLease) waitForLease(ctx context.Context) (time.Time, bool) {
+	ticker := time.NewTicker(l.RetryPeriod)
+	defer ticker.Stop()
+
+	for {
+		attempt := time.Now()
+		acquired, err := l.tryAcquire()
+		if err != nil {
+			logrus.Errorf("Lease %s: %v", l.Path, err)
+		}
+		if acquired {
+			return attempt, true
+		}
+
+		select {
+		case <-ctx.Done():
+			return time.Time{}, false
+		case <-ticker.C:
+		}
+	}
+}
+
+/* Function renewUntilLost:
+ * renews the lease every RenewPeriod; returns when ctx is cancelled, the
+ * lease was taken over, or it could not be renewed within RenewDeadline.
+ * Renewals are timed from before the attempt, which is no later than the
+ * time written in the lease file.
+ */
+func (l *FileLease) renewUntilLost(ctx context.Context, lastRenewal time.Time) {
+	ticker := time.NewTicker(l.RenewPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		attempt := time.Now()
+		renewed, err := l.tryAcquire()
+		if err != nil {
+			logrus.Errorf("Lease %s renewal: %v", l.Path, err)
+		}
+		if renewed {
+			lastRenewal = attempt
+			continue
+		}
+		if err == nil || time.Since(lastRenewal) >= l.RenewDeadline {
+			return // taken over, or about to expire while we could not renew
+		}
+	}
+}
+
+/* Function tryAcquire:
+ * takes or renews the lease if it is free, expired or already ours.
+ */
+func (l *FileLease) tryAcquire() (bool, error) {
+	var acquired bool
+	err := l.withLock(func(f *os.File) error {
+		rec, err := readLease(f)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if rec.Holder != "" && rec.Holder != l.Identity && now.Sub(rec.RenewedAt) < l.LeaseDuration {
+			return nil // held by a live replica
+		}
+
+		acquired = true
+		return writeLease(f, leaseRecord{Holder: l.Identity, RenewedAt: now})
+	})
+	return acquired, err
+}
+
+/* Function release:
+ * hands the lease over immediately on a clean shutdown.
+ */
+func (l *FileLease) release() {
+	err := l.withLock(func(f *os.File) error {
+		rec, err := readLease(f)
+		if err != nil || rec.Holder != l.Identity {
+			return err
+		}
+		return writeLease(f, leaseRecord{})
+	})
+	if err != nil {
+		logrus.Errorf("Releasing lease %s: %v", l.Path, err)
+	}
+}
+
+func (l *FileLease) withLock(fn func(f *os.File) error) error {
+	f, err := os.OpenFile(l.Path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	return fn(f)
+}
+
+func readLease(f *os.File) (leaseRecord, error) {
+	var rec leaseRecord
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return rec, err
+	}
+	err := json.NewDecoder(f).Decode(&rec)
+	if errors.Is(err, io.EOF) { // empty file, nobody holds the lease
+		return leaseRecord{}, nil
+	}
+	return rec, err
+}
+
+func writeLease(f *os.File, rec leaseRecord) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(rec); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,150 @@
+package election
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileLease_TryAcquire(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "lease")
+	first := NewFileLease(path, "replica-1", time.Hour)
+	second := NewFileLease(path, "replica-2", time.Hour)
+
+	tests := []struct {
+		name     string
+		lease    *FileLease
+		acquired bool
+	}{
+		{"free lease", first, true},
+		{"held by another replica", second, false},
+		{"renewed by its holder", first, true},
+	}
+
+	for _, test := range tests {
+		acquired, err := test.lease.tryAcquire()
+		if err != nil {
+			t.Fatalf("%s: tryAcquire() error: %v", test.name, err)
+		}
+		if acquired != test.acquired {
+			t.Errorf("%s: tryAcquire() = %v, want %v", test.name, acquired, test.acquired)
+		}
+	}
+
+	first.release()
+	if acquired, err := second.tryAcquire(); err != nil || !acquired {
+		t.Errorf("tryAcquire() after release = %v, error: %v, want the lease", acquired, err)
+	}
+}
+
+func TestFileLease_Expired(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "lease")
+	first := NewFileLease(path, "replica-1", 20*time.Millisecond)
+	second := NewFileLease(path, "replica-2", 20*time.Millisecond)
+
+	if acquired, err := first.tryAcquire(); err != nil || !acquired {
+		t.Fatalf("tryAcquire() = %v, error: %v, want the lease", acquired, err)
+	}
+	time.Sleep(2 * first.LeaseDuration)
+	if acquired, err := second.tryAcquire(); err != nil || !acquired {
+		t.Errorf("tryAcquire() of an expired lease = %v, error: %v, want the lease", acquired, err)
+	}
+}
+
+func TestFileLease_Run(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "lease")
+	leader := NewFileLease(path, "replica-1", 200*time.Millisecond)
+	standby := NewFileLease(path, "replica-2", 200*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		leader.Run(ctx, Callbacks{OnStartedLeading: func(context.Context) { close(started) }})
+	}()
+	defer func() {
+		cancel()
+		<-leaderDone
+	}()
+	<-started
+
+	standbyCtx, standbyCancel := context.WithCancel(context.Background())
+	takenOver := make(chan struct{})
+	standbyDone := make(chan struct{})
+	go func() {
+		defer close(standbyDone)
+		standby.Run(standbyCtx, Callbacks{OnStartedLeading: func(context.Context) { close(takenOver) }})
+	}()
+	defer func() {
+		standbyCancel()
+		<-standbyDone // neither replica writes the lease file once the test returns
+	}()
+
+	time.Sleep(2 * leader.LeaseDuration) // renewals keep the standby out
+	if !leader.IsLeader() || standby.IsLeader() {
+		t.Fatalf("IsLeader() = %v and %v, want only the first replica leading", leader.IsLeader(), standby.IsLeader())
+	}
+
+	cancel()
+	<-leaderDone // released on a clean shutdown
+	select {
+	case <-takenOver:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the standby did not take over the released lease")
+	}
+	if leader.IsLeader() {
+		t.Error("IsLeader() = true after Run returned")
+	}
+}
+
+func TestFileLease_RenewDeadline(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "shared")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	leader := NewFileLease(filepath.Join(dir, "lease"), "replica-1", 500*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan time.Time, 1)
+	stopped := make(chan time.Time, 1)
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		leader.Run(ctx, Callbacks{
+			OnStartedLeading: func(context.Context) { started <- time.Now() },
+			OnStoppedLeading: func() { stopped <- time.Now() },
+		})
+	}()
+	defer func() {
+		cancel()
+		<-leaderDone
+	}()
+	acquiredAt := <-started
+
+	// Renewals fail from now on, without the lease being taken over
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case at := <-stopped:
+		if held := at.Sub(acquiredAt); held >= leader.LeaseDuration {
+			t.Errorf("the leader stepped down %v after acquiring the lease, not before it expired after %v", held, leader.LeaseDuration)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the leader did not step down")
+	}
+	if leader.IsLeader() {
+		t.Error("IsLeader() = true after stepping down")
+	}
+}
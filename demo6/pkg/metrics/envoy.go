@@ -0,0 +1,227 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	metricsservice "github.com/envoyproxy/go-control-plane/envoy/service/metrics/v3"
+)
+
+// Prefix of the re-exposed Envoy stats, as in the Prometheus output of the Envoy admin interface
+const envoyPrefix = "envoy_"
+
+type nodeStats struct {
+	stream   uint64                       // stream the stats are flushed on, a reconnection replacing it
+	families map[string]*dto.MetricFamily // last flushed value, by name
+	updated  time.Time
+}
+
+/* Structure EnvoyStats:
+ * an Envoy MetricsService keeping the last stats flushed by every node,
+ * and a Prometheus collector re-exposing them labelled with the node
+ * and the swarm service it belongs to. The stats of a node are dropped
+ * when its stream ends, unless the node already flushes on a new one.
+ * Authorize, when set, checks the node of every stream, e.g., against
+ * its client certificate as for xDS streams.
+ */
+type EnvoyStats struct {
+	serviceOf func(nodeID string) string
+	Authorize func(ctx context.Context, node *core.Node) error
+
+	mu      sync.Mutex
+	nodes   map[string]*nodeStats
+	streams uint64 // streams opened so far, numbering them
+}
+
+var (
+	_ metricsservice.MetricsServiceServer = &EnvoyStats{}
+	_ prometheus.Collector                = &EnvoyStats{}
+)
+
+var envoyStatsLastFlush = prometheus.NewDesc(
+	namespace+"_envoy_stats_last_flush_timestamp_seconds",
+	"When a node last flushed its stats to the metrics service.",
+	[]string{"node", "service"}, nil,
+)
+
+/* Function NewEnvoyStats:
+ * serviceOf returns the swarm service of a node ID, empty if unknown.
+ */
+func NewEnvoyStats(serviceOf func(nodeID string) string) *EnvoyStats {
+	return &EnvoyStats{
+		serviceOf: serviceOf,
+		nodes:     make(map[string]*nodeStats),
+	}
+}
+
+/* Function StreamMetrics:
+ * receives the stats of one Envoy. Only the first message of a stream
+ * carries the node. A flush may be split across messages, so families
+ * are replaced one by one rather than all at once.
+ */
+func (s *EnvoyStats) StreamMetrics(stream metricsservice.MetricsService_StreamMetricsServer) error {
+	var nodeID string
+	s.mu.Lock()
+	s.streams++
+	streamID := s.streams
+	s.mu.Unlock()
+
+	defer func() {
+		if nodeID == "" {
+			return
+		}
+		s.mu.Lock()
+		if n, ok := s.nodes[nodeID]; ok && n.stream == streamID {
+			delete(s.nodes, nodeID)
+		}
+		s.mu.Unlock()
+	}()
+
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&metricsservice.StreamMetricsResponse{})
+		}
+		if err != nil {
+			return err
+		}
+		if node := msg.GetIdentifier().GetNode(); node.GetId() != "" && nodeID == "" {
+			if s.Authorize != nil {
+				if err := s.Authorize(stream.Context(), node); err != nil {
+					return err
+				}
+			}
+			nodeID = node.GetId()
+			logrus.Debugf("Receiving stats of node %s", nodeID)
+		}
+		if nodeID == "" {
+			continue // the identifier never came
+		}
+
+		s.mu.Lock()
+		n, ok := s.nodes[nodeID]
+		if !ok || n.stream != streamID {
+			n = &nodeStats{stream: streamID, families: make(map[string]*dto.MetricFamily)}
+			s.nodes[nodeID] = n
+		}
+		for _, f := range msg.GetEnvoyMetrics() {
+			n.families[f.GetName()] = f
+		}
+		n.updated = time.Now()
+		s.mu.Unlock()
+	}
+}
+
+/* Function Describe:
+ * sends nothing: which stats Envoy flushes is only known once it does,
+ * which makes EnvoyStats an unchecked collector.
+ */
+func (s *EnvoyStats) Describe(chan<- *prometheus.Desc) {}
+
+/* Function Collect:
+ * skips the samples whose sanitized name collides with one already sent
+ * with other labels or another type, e.g., "a.b" and "a_b", as it would
+ * fail the whole scrape.
+ */
+func (s *EnvoyStats) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool)
+	types := make(map[string]dto.MetricType)
+	for nodeID, n := range s.nodes {
+		service := s.serviceOf(nodeID)
+		ch <- prometheus.MustNewConstMetric(envoyStatsLastFlush, prometheus.GaugeValue,
+			float64(n.updated.UnixNano())/1e9, nodeID, service)
+
+		for _, f := range n.families {
+			name := sanitizeName(f.GetName())
+			if t, ok := types[name]; ok && t != f.GetType() {
+				continue
+			}
+			types[name] = f.GetType()
+			for _, m := range f.GetMetric() {
+				metric, err := constMetric(f, m, nodeID, service)
+				if err != nil {
+					logrus.Debugf("Skipping Envoy stat %s of node %s: %v", f.GetName(), nodeID, err)
+					continue
+				}
+				key := metric.Desc().String() + "\xff" + nodeID + sampleKey(m)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				ch <- metric
+			}
+		}
+	}
+}
+
+/* Function constMetric:
+ * converts one sample of a flushed family, adding the node and service
+ * labels to the tags Envoy extracted from the stat name.
+ */
+func constMetric(f *dto.MetricFamily, m *dto.Metric, nodeID, service string) (prometheus.Metric, error) {
+	names := []string{"node", "service"}
+	values := []string{nodeID, service}
+	for _, l := range m.GetLabel() {
+		if name := sanitizeName(l.GetName()); name != "node" && name != "service" {
+			names = append(names, name)
+			values = append(values, l.GetValue())
+		}
+	}
+	name := envoyPrefix + sanitizeName(f.GetName())
+	desc := prometheus.NewDesc(name, "Envoy stat "+name+".", names, nil)
+
+	switch f.GetType() {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, m.GetCounter().GetValue(), values...)
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), values...)
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		buckets := make(map[float64]uint64, len(h.GetBucket()))
+		for _, b := range h.GetBucket() {
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+		return prometheus.NewConstHistogram(desc, h.GetSampleCount(), h.GetSampleSum(), buckets, values...)
+	case dto.MetricType_SUMMARY:
+		q := m.GetSummary()
+		quantiles := make(map[float64]float64, len(q.GetQuantile()))
+		for _, v := range q.GetQuantile() {
+			quantiles[v.GetQuantile()] = v.GetValue()
+		}
+		return prometheus.NewConstSummary(desc, q.GetSampleCount(), q.GetSampleSum(), quantiles, values...)
+	}
+	return prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), values...)
+}
+
+func sampleKey(m *dto.Metric) string {
+	var b strings.Builder
+	for _, l := range m.GetLabel() {
+		b.WriteString("\xff" + sanitizeName(l.GetName()) + "=" + l.GetValue())
+	}
+	return b.String()
+}
+
+/* Function sanitizeName:
+ * maps an Envoy stat or tag name, e.g., "cluster.upstream_rq_total" or
+ * "envoy.cluster_name", to a Prometheus name.
+ */
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
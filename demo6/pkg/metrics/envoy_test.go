@@ -0,0 +1,133 @@
+package metrics_test
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"envoy-swarm-control/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	metricsservice "github.com/envoyproxy/go-control-plane/envoy/service/metrics/v3"
+)
+
+// A metrics stream fed by the test, ending with io.EOF once messages is closed
+type fakeStream struct {
+	grpc.ServerStream
+	messages chan *metricsservice.StreamMetricsMessage
+}
+
+func (s *fakeStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *fakeStream) Recv() (*metricsservice.StreamMetricsMessage, error) {
+	msg, ok := <-s.messages
+	if !ok {
+		return nil, io.EOF
+	}
+	return msg, nil
+}
+
+func (s *fakeStream) SendAndClose(*metricsservice.StreamMetricsResponse) error {
+	return nil
+}
+
+func counter(name string, value float64, labels ...string) *dto.MetricFamily {
+	m := &dto.Metric{Counter: &dto.Counter{Value: proto.Float64(value)}}
+	for i := 0; i+1 < len(labels); i += 2 {
+		m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(labels[i]), Value: proto.String(labels[i+1])})
+	}
+	return &dto.MetricFamily{Name: proto.String(name), Type: dto.MetricType_COUNTER.Enum(), Metric: []*dto.Metric{m}}
+}
+
+func gauge(name string, value float64) *dto.MetricFamily {
+	m := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(value)}}
+	return &dto.MetricFamily{Name: proto.String(name), Type: dto.MetricType_GAUGE.Enum(), Metric: []*dto.Metric{m}}
+}
+
+// Samples of the gathered families by name, as "<node>/<service>/<value>"
+func gather(t *testing.T, registry *prometheus.Registry) map[string][]string {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error: %v", err)
+	}
+	out := make(map[string][]string)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			value := m.GetCounter().GetValue() + m.GetGauge().GetValue()
+			out[f.GetName()] = append(out[f.GetName()], labels["node"]+"/"+labels["service"]+"/"+strconv.FormatFloat(value, 'g', -1, 64))
+		}
+	}
+	return out
+}
+
+func TestEnvoyStats_Collect(t *testing.T) {
+	t.Parallel()
+
+	stats := metrics.NewEnvoyStats(func(nodeID string) string { return "service-of-" + nodeID })
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(stats)
+
+	stream := &fakeStream{messages: make(chan *metricsservice.StreamMetricsMessage)}
+	done := make(chan error)
+	go func() { done <- stats.StreamMetrics(stream) }()
+
+	stream.messages <- &metricsservice.StreamMetricsMessage{
+		Identifier: &metricsservice.StreamMetricsMessage_Identifier{Node: &core.Node{Id: "local_node_1"}},
+		EnvoyMetrics: []*dto.MetricFamily{
+			counter("cluster.upstream_rq_total", 5, "envoy.cluster_name", "app"),
+			gauge("cluster_manager.active_clusters", 3),
+			counter("cluster_manager_active_clusters", 4), // collides with the gauge once sanitized
+		},
+	}
+	stream.messages <- &metricsservice.StreamMetricsMessage{
+		EnvoyMetrics: []*dto.MetricFamily{
+			counter("cluster.upstream_rq_total", 6, "envoy.cluster_name", "app"), // a later flush replaces the value
+			gauge("server.live", 1),
+		},
+	}
+	stream.messages <- &metricsservice.StreamMetricsMessage{} // Recv returns once the flush above is stored
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"envoy_server_live", "local_node_1/service-of-local_node_1/1"},
+		{"envoy_cluster_upstream_rq_total", "local_node_1/service-of-local_node_1/6"},
+	}
+	got := gather(t, registry)
+	for _, test := range tests {
+		if samples := got[test.name]; len(samples) != 1 || samples[0] != test.want {
+			t.Errorf("%s = %v, want [%s]", test.name, samples, test.want)
+		}
+	}
+	if samples := got["envoy_cluster_manager_active_clusters"]; len(samples) != 1 {
+		t.Errorf("envoy_cluster_manager_active_clusters = %v, want a single sample", samples)
+	}
+
+	close(stream.messages)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("StreamMetrics() error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StreamMetrics() did not return at the end of the stream")
+	}
+	if got := gather(t, registry); len(got) != 0 {
+		t.Errorf("Gather() after the stream ended = %v, want nothing", got)
+	}
+}
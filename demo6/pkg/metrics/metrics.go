@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "envoy_swarm_control"
+
+var (
+	OpenStreams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "xds_open_streams",
+		Help:      "Number of open xDS streams per node.",
+	}, []string{"node"})
+
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "xds_requests_total",
+		Help:      "xDS discovery requests received, per node and type URL.",
+	}, []string{"node", "type_url"})
+
+	Responses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "xds_responses_total",
+		Help:      "xDS discovery responses sent, per node and type URL.",
+	}, []string{"node", "type_url"})
+
+	Acks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "xds_acks_total",
+		Help:      "Configuration versions accepted by Envoy, per node and type URL.",
+	}, []string{"node", "type_url"})
+
+	Nacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "xds_nacks_total",
+		Help:      "Configuration versions rejected by Envoy, per node and type URL.",
+	}, []string{"node", "type_url"})
+
+	TimeToAck = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "xds_time_to_ack_seconds",
+		Help:      "Time between sending a response and receiving its ACK.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12), // 5ms to ~10s
+	}, []string{"type_url"})
+
+	SnapshotBuildDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "snapshot_build_duration_seconds",
+		Help:      "Time taken to build, validate and publish a snapshot.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"node"})
+
+	SnapshotUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snapshot_updates_total",
+		Help:      "Snapshot updates per node, by result (published, unchanged, failed, rollback).",
+	}, []string{"node", "result"})
+
+	SnapshotVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "snapshot_version_info",
+		Help:      "Always 1; labelled with the version currently served per node and type URL.",
+	}, []string{"node", "type_url", "version"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		OpenStreams,
+		Requests,
+		Responses,
+		Acks,
+		Nacks,
+		TimeToAck,
+		SnapshotBuildDuration,
+		SnapshotUpdates,
+		SnapshotVersion,
+	)
+}
+
+/* Function SetSnapshotVersion:
+ * replaces the version served to a node for a type URL, an empty version
+ * removing it once the node is no longer served resources of that type.
+ */
+func SetSnapshotVersion(node, typeURL, version string) {
+	SnapshotVersion.DeletePartialMatch(prometheus.Labels{"node": node, "type_url": typeURL})
+	if version != "" {
+		SnapshotVersion.WithLabelValues(node, typeURL, version).Set(1)
+	}
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
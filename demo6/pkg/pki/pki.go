@@ -0,0 +1,196 @@
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"envoy-sds/cert" // demo7/variant-1/cert
+)
+
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+	keyBits    = 2048
+)
+
+/* Function ServerTLSConfig:
+ * returns the TLS configuration of the xDS server. With a client CA, every
+ * Envoy has to present a certificate issued by it, others are refused
+ * during the handshake.
+ */
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	serverCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading server certificate: %w", err)
+	}
+
+	c := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile == "" {
+		return c, nil
+	}
+
+	caBytes, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBytes) {
+		return nil, fmt.Errorf("no certificate found in %s", clientCAFile)
+	}
+	c.ClientCAs = pool
+	c.ClientAuth = tls.RequireAndVerifyClientCert
+	return c, nil
+}
+
+/* Structure Authority:
+ * the CA issuing the control plane and Envoy certificates, kept as ca.crt
+ * and ca.key in Dir.
+ */
+type Authority struct {
+	Dir  string
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+/* Function LoadOrCreateAuthority:
+ * loads the CA of dir, or creates one on first use, so that certificates
+ * issued later on are trusted by everything issued before.
+ */
+func LoadOrCreateAuthority(dir string) (*Authority, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	a := &Authority{Dir: dir}
+	certPem, err := os.ReadFile(filepath.Join(dir, caCertFile))
+	if errors.Is(err, os.ErrNotExist) {
+		var certBytes, keyBytes []byte
+		a.cert, certBytes, a.key, keyBytes, err = cert.GenCARoot()
+		if err != nil {
+			return nil, err
+		}
+		return a, a.write(caCertFile, caKeyFile, certBytes, keyBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keyPem, err := os.ReadFile(filepath.Join(dir, caKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	if a.cert, err = parseCertificate(certPem); err != nil {
+		return nil, fmt.Errorf("%s: %w", caCertFile, err)
+	}
+	if a.key, err = parsePrivateKey(keyPem); err != nil {
+		return nil, fmt.Errorf("%s: %w", caKeyFile, err)
+	}
+	return a, nil
+}
+
+/* Function IssueServer:
+ * writes <name>.crt and <name>.key for server authentication only, e.g.,
+ * for the xDS server. The first DNS name becomes the common name.
+ */
+func (a *Authority) IssueServer(name string, dnsNames []string, validity time.Duration) error {
+	return a.issue(name, dnsNames, nil, x509.ExtKeyUsageServerAuth, validity)
+}
+
+/* Function IssueClient:
+ * writes <name>.crt and <name>.key for client authentication only, e.g.,
+ * for an Envoy connecting to the xDS server, with URI SANs such as
+ * SPIFFE IDs besides the DNS names.
+ */
+func (a *Authority) IssueClient(name string, dnsNames []string, uris []*url.URL, validity time.Duration) error {
+	return a.issue(name, dnsNames, uris, x509.ExtKeyUsageClientAuth, validity)
+}
+
+/* Function issue:
+ * signs a new key with the CA. Serial numbers are random 128-bit numbers,
+ * so certificates issued within the same second never share one.
+ */
+func (a *Authority) issue(name string, dnsNames []string, uris []*url.URL, usage x509.ExtKeyUsage, validity time.Duration) error {
+	if len(dnsNames) == 0 {
+		return errors.New("at least one DNS name is required")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: a.cert.Subject.Organization,
+			CommonName:   dnsNames[0],
+		},
+		DNSNames:    dnsNames,
+		URIs:        uris,
+		NotBefore:   time.Now().Add(-10 * time.Second),
+		NotAfter:    time.Now().Add(validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	certBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	return a.write(name+".crt", name+".key", certBytes, keyBytes)
+}
+
+func (a *Authority) write(certFile, keyFile string, certBytes, keyBytes []byte) error {
+	if err := os.WriteFile(filepath.Join(a.Dir, certFile), certBytes, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(a.Dir, keyFile), keyBytes, 0o600)
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA private key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,148 @@
+package snapshot
+
+import (
+	"sort"
+
+	"envoy-swarm-control/pkg/audit"
+	"envoy-swarm-control/pkg/xdstypes"
+
+	"google.golang.org/protobuf/proto"
+
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+// Kinds of the audit entries written by the manager
+const (
+	AuditConfigChange = "config-change"
+	AuditConfigAck    = "config-ack"
+)
+
+/* Structure ChangeRecord:
+ * the audit record of a snapshot change of a node. Versions are those
+ * served to, and acknowledged by, the node.
+ */
+type ChangeRecord struct {
+	Trigger     string                     `json:"trigger"`         // published, rollback or restored
+	Event       string                     `json:"event,omitempty"` // swarm event behind a published change
+	OldVersions map[string]string          `json:"old_versions,omitempty"`
+	NewVersions map[string]string          `json:"new_versions"`
+	Resources   map[string]ResourceChanges `json:"resources"` // type URL -> changed resources
+}
+
+/* Structure ResourceChanges:
+ * names of the resources of one type that differ between two snapshots.
+ */
+type ResourceChanges struct {
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+}
+
+/* Structure AckRecord:
+ * the audit record of a node accepting or rejecting a version.
+ */
+type AckRecord struct {
+	TypeURL string `json:"type_url"`
+	Version string `json:"version"`
+	Acked   bool   `json:"acked"`
+	Error   string `json:"error,omitempty"`
+}
+
+/* Function DiffSnapshots:
+ * compares two snapshots resource by resource; old may be nil. Types
+ * without any change are left out.
+ */
+func DiffSnapshots(old, new cache.ResourceSnapshot) map[string]ResourceChanges {
+	out := make(map[string]ResourceChanges)
+	for _, typ := range xdstypes.ResourceTypes {
+		var before, after map[string]proto.Message
+		if old != nil {
+			before = messagesOf(old, typ)
+		}
+		if new != nil {
+			after = messagesOf(new, typ)
+		}
+
+		var c ResourceChanges
+		for name, res := range after {
+			prev, ok := before[name]
+			switch {
+			case !ok:
+				c.Added = append(c.Added, name)
+			case !proto.Equal(prev, res):
+				c.Modified = append(c.Modified, name)
+			}
+		}
+		for name := range before {
+			if _, ok := after[name]; !ok {
+				c.Removed = append(c.Removed, name)
+			}
+		}
+		if len(c.Added)+len(c.Removed)+len(c.Modified) == 0 {
+			continue
+		}
+		sort.Strings(c.Added)
+		sort.Strings(c.Removed)
+		sort.Strings(c.Modified)
+		out[typ] = c
+	}
+	return out
+}
+
+func messagesOf(snap cache.ResourceSnapshot, typeURL string) map[string]proto.Message {
+	items := snap.GetResources(typeURL)
+	out := make(map[string]proto.Message, len(items))
+	for name, res := range items {
+		out[name] = res
+	}
+	return out
+}
+
+/* Function auditChange:
+ * records what changed for a node once a snapshot has been set, with the
+ * versions the cache now serves.
+ */
+func (m *Manager) auditChange(nodeID, service string, old cache.ResourceSnapshot, trigger, event string) {
+	if m.audit == nil {
+		return
+	}
+	served, err := m.snapshotCache.GetSnapshot(nodeID)
+	if err != nil {
+		return
+	}
+
+	record := ChangeRecord{
+		Trigger:     trigger,
+		Event:       event,
+		NewVersions: snapshotVersions(served),
+		Resources:   DiffSnapshots(old, served),
+	}
+	if old != nil {
+		record.OldVersions = snapshotVersions(old)
+	}
+	if service == "" {
+		service = m.ServiceOfNode(nodeID)
+	}
+	m.audit.Record(audit.Entry{Kind: AuditConfigChange, Node: nodeID, Service: service, Detail: record})
+}
+
+func (m *Manager) auditAck(nodeID string, record AckRecord) {
+	if m.audit == nil {
+		return
+	}
+	m.audit.Record(audit.Entry{Kind: AuditConfigAck, Node: nodeID, Service: m.ServiceOfNode(nodeID), Detail: record})
+}
+
+/* Function ServiceOfNode:
+ * returns the name of the service configuring a node, if known.
+ */
+func (m *Manager) ServiceOfNode(nodeID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, h := range m.services {
+		if h.NodeID == nodeID {
+			return name
+		}
+	}
+	return ""
+}
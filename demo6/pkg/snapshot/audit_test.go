@@ -0,0 +1,75 @@
+package snapshot_test
+
+import (
+	"reflect"
+	"testing"
+
+	"envoy-swarm-control/pkg/snapshot"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+func newSnapshot(t *testing.T, resources map[string][]types.Resource) *cache.Snapshot {
+	t.Helper()
+	snap, err := cache.NewSnapshot("1", resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snap
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	t.Parallel()
+
+	old := newSnapshot(t, map[string][]types.Resource{
+		resource.ClusterType: {
+			&cluster.Cluster{Name: "kept"},
+			&cluster.Cluster{Name: "modified"},
+			&cluster.Cluster{Name: "removed"},
+		},
+		resource.RouteType: {&route.RouteConfiguration{Name: "routes"}},
+	})
+	new := newSnapshot(t, map[string][]types.Resource{
+		resource.ClusterType: {
+			&cluster.Cluster{Name: "kept"},
+			&cluster.Cluster{Name: "modified", AltStatName: "changed"},
+			&cluster.Cluster{Name: "added"},
+		},
+		resource.RouteType: {&route.RouteConfiguration{Name: "routes"}},
+	})
+
+	tests := []struct {
+		name     string
+		old, new cache.ResourceSnapshot
+		want     map[string]snapshot.ResourceChanges
+	}{
+		{"unchanged", old, old, map[string]snapshot.ResourceChanges{}},
+		{"changed", old, new, map[string]snapshot.ResourceChanges{
+			resource.ClusterType: {Added: []string{"added"}, Removed: []string{"removed"}, Modified: []string{"modified"}},
+		}},
+		{"first snapshot", nil, old, map[string]snapshot.ResourceChanges{
+			resource.ClusterType: {Added: []string{"kept", "modified", "removed"}},
+			resource.RouteType:   {Added: []string{"routes"}},
+		}},
+		{"cleared", old, nil, map[string]snapshot.ResourceChanges{
+			resource.ClusterType: {Removed: []string{"kept", "modified", "removed"}},
+			resource.RouteType:   {Removed: []string{"routes"}},
+		}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := snapshot.DiffSnapshots(test.old, test.new)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("DiffSnapshots() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package snapshot
+
+import (
+	"fmt"
+	"time"
+
+	"envoy-swarm-control/pkg/configresource"
+
+	"google.golang.org/protobuf/proto"
+
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+/* Function BuildSnapshot:
+ * generates the snapshot a service's labels translate to, versioned by
+ * content, without publishing it. Used to preview label changes.
+ */
+func BuildSnapshot(update ServiceLabels, runtime *RuntimeFile) (*cache.Snapshot, error) {
+	resources, err := buildResources(update, runtime)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := newSnapshot(resources)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot versioning: %w", err)
+	}
+	return snap, nil
+}
+
+/* Function buildResources:
+ * runs the configresource builders for the node of a service.
+ */
+func buildResources(update ServiceLabels, runtime *RuntimeFile) (map[string][]types.Resource, error) {
+	cluster := configresource.ProvideCluster(
+		fmt.Sprintf("%s_cluster", update.Status.NodeID),
+		update.Route.UpstreamHost,
+		update.Endpoint.Port.PortValue,
+	)
+	filters := httpFilters(update)
+	accessLogs, err := configresource.ProvideAccessLogs(
+		serviceName(update),
+		configresource.DefaultAccessLog.Merge(update.AccessLog),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tracing := configresource.DefaultTracing.Merge(update.Tracing)
+	var httpTracing *hcm.HttpConnectionManager_Tracing
+	if tracing.Enabled() {
+		if httpTracing, err = configresource.ProvideTracing(
+			serviceName(update),
+			fmt.Sprintf("%s_tracing", update.Status.NodeID),
+			tracing,
+		); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := configresource.ProvideHTTPListener(
+		fmt.Sprintf("%s_listener", update.Status.NodeID),
+		fmt.Sprintf("%s_route", update.Status.NodeID),
+		update.Listener.Port.PortValue,
+		filters,
+		accessLogs,
+		httpTracing,
+	)
+	if err != nil {
+		return nil, err
+	}
+	extensions := make([]types.Resource, 0, len(filters))
+	for _, f := range filters {
+		extension, err := configresource.ProvideExtensionConfig(f)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, extension)
+	}
+	route, err := configresource.ProvideRoute(
+		fmt.Sprintf("%s_route", update.Status.NodeID),
+		fmt.Sprintf("%s_service", update.Status.NodeID),
+		fmt.Sprintf("%s_cluster", update.Status.NodeID),
+		update.Route.UpstreamHost,
+		update.Route.PathPrefix,
+		update.Endpoint.RequestTimeout,
+		update.Route.RequestHeaders,
+		update.Route.ResponseHeaders,
+		perFilterConfig(update),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if paths := update.Auth.DisabledPaths; len(paths) > 0 {
+		disabled := map[string]proto.Message{extAuthzFilterName(update): configresource.ProvideExtAuthzDisabled()}
+		if err := configresource.AddPathOverrides(route, paths, disabled); err != nil {
+			return nil, err
+		}
+	}
+	secret := configresource.ProvideSecret()
+	layer, err := configresource.ProvideRuntime(runtime.Layer(update.Status.NodeID, update.Runtime))
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make(map[string][]types.Resource, 6)
+	resources[resource.ClusterType] = []types.Resource{cluster}
+	if a := update.Auth; a.ExtAuthzHost != "" {
+		authzCluster, err := configresource.ProvideGRPCCluster(
+			fmt.Sprintf("%s_ext_authz", update.Status.NodeID),
+			a.ExtAuthzHost,
+			a.ExtAuthzPort,
+		)
+		if err != nil {
+			return nil, err
+		}
+		resources[resource.ClusterType] = append(resources[resource.ClusterType], authzCluster)
+	}
+	if tracing.Enabled() {
+		tracingCluster, err := configresource.ProvideTracingCluster(fmt.Sprintf("%s_tracing", update.Status.NodeID), tracing)
+		if err != nil {
+			return nil, err
+		}
+		resources[resource.ClusterType] = append(resources[resource.ClusterType], tracingCluster)
+	}
+	resources[resource.RouteType] = []types.Resource{route}
+	resources[resource.ListenerType] = []types.Resource{listener}
+	resources[resource.RuntimeType] = []types.Resource{layer}
+	if len(extensions) > 0 {
+		resources[resource.ExtensionConfigType] = extensions
+	}
+	if secret != nil {
+		resources[resource.SecretType] = []types.Resource{secret}
+	}
+	return resources, nil
+}
+
+/* Function serviceName:
+ * the name the access log service groups the entries of a service by,
+ * and the service of its spans; the node ID when previewing labels
+ * without a service.
+ */
+func serviceName(update ServiceLabels) string {
+	if update.ServiceName != "" {
+		return update.ServiceName
+	}
+	return update.Status.NodeID
+}
+
+/* Function httpFilters:
+ * the HTTP filters the labels of a service ask for, in chain order.
+ */
+func httpFilters(update ServiceLabels) []configresource.HTTPFilter {
+	var filters []configresource.HTTPFilter
+	if len(update.Cors.AllowOrigins) > 0 {
+		filters = append(filters, configresource.ProvideCorsFilter(fmt.Sprintf("%s_cors", update.Status.NodeID)))
+	}
+	if a := update.Auth; a.ExtAuthzHost != "" {
+		filters = append(filters, configresource.ProvideExtAuthzFilter(
+			extAuthzFilterName(update),
+			fmt.Sprintf("%s_ext_authz", update.Status.NodeID),
+			a.Timeout,
+			a.FailureModeAllow,
+		))
+	}
+	if update.RateLimit.RequestsPerUnit > 0 {
+		filters = append(filters, configresource.ProvideLocalRateLimitFilter(fmt.Sprintf("%s_ratelimit", update.Status.NodeID)))
+	}
+	if f := update.Fault; f.Enabled() {
+		filters = append(filters, configresource.ProvideFaultFilter(
+			fmt.Sprintf("%s_fault", update.Status.NodeID),
+			f.Delay, faultPercent(f.DelayPercent), f.AbortStatus, faultPercent(f.AbortPercent),
+		))
+	}
+	return filters
+}
+
+/* Function perFilterConfig:
+ * the virtual host and route specific configurations of the filters of
+ * httpFilters.
+ */
+func perFilterConfig(update ServiceLabels) configresource.PerFilterConfig {
+	configs := configresource.PerFilterConfig{
+		VirtualHost: make(map[string]proto.Message),
+		Route:       make(map[string]proto.Message),
+	}
+	if c := update.Cors; len(c.AllowOrigins) > 0 {
+		configs.VirtualHost[fmt.Sprintf("%s_cors", update.Status.NodeID)] = configresource.ProvideCorsPolicy(
+			c.AllowOrigins, c.AllowMethods, c.AllowHeaders, c.ExposeHeaders, c.MaxAge, c.AllowCredentials,
+		)
+	}
+	if r := update.RateLimit; r.RequestsPerUnit > 0 {
+		unit, burst := r.Unit, r.Burst
+		if unit == 0 {
+			unit = time.Second
+		}
+		if burst == 0 {
+			burst = r.RequestsPerUnit
+		}
+		configs.Route[fmt.Sprintf("%s_ratelimit", update.Status.NodeID)] = configresource.ProvideLocalRateLimit(r.RequestsPerUnit, unit, burst, r.ResponseHeaders)
+	}
+	return configs
+}
+
+func extAuthzFilterName(update ServiceLabels) string {
+	return fmt.Sprintf("%s_ext_authz", update.Status.NodeID)
+}
@@ -3,11 +3,15 @@ package snapshot
 import (
 	"errors"
 	"fmt"
+	"net"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"envoy-swarm-control/pkg/configresource"
+
 	types "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 )
 
@@ -27,19 +31,101 @@ type ServiceEndpoint struct {
 }
 
 type ServiceRoute struct {
-	UpstreamHost string
-	PathPrefix   string
+	UpstreamHost    string
+	PathPrefix      string
+	RequestHeaders  configresource.HeaderMutation
+	ResponseHeaders configresource.HeaderMutation
+}
+
+/* Structure ServiceAuth:
+ * external authorization of the requests of a service by a gRPC
+ * authorization server such as the one of demo5, except on DisabledPaths.
+ */
+type ServiceAuth struct {
+	ExtAuthzHost     string
+	ExtAuthzPort     uint32
+	Timeout          time.Duration
+	FailureModeAllow bool     // let requests through when the server fails to answer
+	DisabledPaths    []string `json:",omitempty"` // path prefixes within route.path
+}
+
+/* Structure ServiceCors:
+ * the CORS policy of a service, enabled by AllowOrigins.
+ */
+type ServiceCors struct {
+	AllowOrigins     []string `json:",omitempty"`
+	AllowMethods     string
+	AllowHeaders     string
+	ExposeHeaders    string
+	MaxAge           time.Duration
+	AllowCredentials bool
+}
+
+/* Structure ServiceFault:
+ * faults injected into the requests of a service, e.g., to test how its
+ * clients cope. Percentages left unset default to every request, an
+ * explicit 0 keeps the fault configured but injects it into none.
+ */
+type ServiceFault struct {
+	Delay        time.Duration
+	DelayPercent *uint32 `json:",omitempty"`
+	AbortStatus  uint32  // HTTP status returned instead of forwarding the request
+	AbortPercent *uint32 `json:",omitempty"`
+}
+
+func (f ServiceFault) Enabled() bool {
+	return f.Delay > 0 || f.AbortStatus > 0
+}
+
+/* Function faultPercent:
+ * returns p, or 100 if it was not set.
+ */
+func faultPercent(p *uint32) uint32 {
+	if p == nil {
+		return 100
+	}
+	return *p
+}
+
+/* Structure ServiceRateLimit:
+ * a local rate limit of the requests routed to a service, enforced by
+ * each Envoy on its own. Unit defaults to a second, Burst to
+ * RequestsPerUnit.
+ */
+type ServiceRateLimit struct {
+	RequestsPerUnit uint32
+	Unit            time.Duration
+	Burst           uint32
+	ResponseHeaders map[string]string `json:",omitempty"` // added to 429 responses
 }
 
 type ServiceLabels struct {
-	Status   ServiceStatus
-	Listener ServiceListener
-	Endpoint ServiceEndpoint
-	Route    ServiceRoute
+	ServiceName string // name of the swarm service, set by the watcher rather than a label
+	Event       string `json:",omitempty"` // swarm event behind the update, set by the watcher
+
+	Status    ServiceStatus
+	Listener  ServiceListener
+	Endpoint  ServiceEndpoint
+	Route     ServiceRoute
+	Cors      ServiceCors
+	Auth      ServiceAuth
+	Fault     ServiceFault
+	RateLimit ServiceRateLimit
+	AccessLog configresource.AccessLog // fields left empty fall back to the control plane configuration
+	Tracing   configresource.Tracing   // likewise
+	Runtime   map[string]string        `json:",omitempty"` // envoy.runtime.<key> labels, served over RTDS
+
+	invalid []error // labels that could not be parsed, reported by Validate
 }
 
+// Shortest token bucket refill interval accepted by Envoy's local rate limit
+const minRateLimitUnit = 50 * time.Millisecond
+
 var serviceLabelRegex = regexp.MustCompile(`(?Uim)envoy\.(?P<type>\S+)\.(?P<property>\S+$)`)
 
+// HTTP header field names, lowercase as parsed from the labels (RFC 9110 tokens)
+var headerNameRegex = regexp.MustCompile("^[a-z0-9!#$%&'*+.^_`|~-]+$")
+
 func ParseServiceLabels(labels map[string]string) *ServiceLabels {
 	var s ServiceLabels
 	for key, value := range labels {
@@ -57,6 +143,20 @@ func ParseServiceLabels(labels map[string]string) *ServiceLabels {
 			s.setEndpointProperty(matches[2], value)
 		case "route":
 			s.setRouteProperty(matches[2], value)
+		case "fault":
+			s.setFaultProperty(matches[2], value)
+		case "auth":
+			s.setAuthProperty(matches[2], value)
+		case "cors":
+			s.setCorsProperty(matches[2], value)
+		case "ratelimit":
+			s.setRateLimitProperty(matches[2], value)
+		case "accesslog":
+			s.setAccessLogProperty(matches[2], value)
+		case "tracing":
+			s.setTracingProperty(matches[2], value)
+		case "runtime":
+			s.setRuntimeProperty(matches[2], value)
 		}
 	}
 
@@ -106,10 +206,224 @@ func (l *ServiceLabels) setRouteProperty(property, value string) {
 		l.Route.PathPrefix = fmt.Sprintf("/%s", strings.TrimPrefix(value, "/"))
 	case "upstream-host":
 		l.Route.UpstreamHost = value
+	case "request-headers-add":
+		l.Route.RequestHeaders.Add = parseHeaderList(value)
+	case "request-headers-set":
+		l.Route.RequestHeaders.Set = parseHeaderList(value)
+	case "request-headers-remove":
+		l.Route.RequestHeaders.Remove = parseNameList(value)
+	case "response-headers-add":
+		l.Route.ResponseHeaders.Add = parseHeaderList(value)
+	case "response-headers-set":
+		l.Route.ResponseHeaders.Set = parseHeaderList(value)
+	case "response-headers-remove":
+		l.Route.ResponseHeaders.Remove = parseNameList(value)
+	}
+}
+
+// Port of the demo5 authorization server
+const defaultExtAuthzPort = 4040
+
+func (l *ServiceLabels) setAuthProperty(property, value string) {
+	switch strings.ToLower(property) {
+	case "ext-authz":
+		host, port := value, uint64(defaultExtAuthzPort)
+		if h, p, err := net.SplitHostPort(value); err == nil {
+			host = h
+			port, _ = strconv.ParseUint(p, 10, 16)
+		}
+		l.Auth.ExtAuthzHost = host
+		l.Auth.ExtAuthzPort = uint32(port)
+	case "timeout":
+		if timeout, err := time.ParseDuration(value); err == nil {
+			l.Auth.Timeout = timeout
+		}
+	case "failure-mode-allow":
+		l.Auth.FailureModeAllow, _ = strconv.ParseBool(value)
+	case "disable-paths":
+		for _, path := range strings.Split(value, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				l.Auth.DisabledPaths = append(l.Auth.DisabledPaths, fmt.Sprintf("/%s", strings.TrimPrefix(path, "/")))
+			}
+		}
+	}
+}
+
+func (l *ServiceLabels) setCorsProperty(property, value string) {
+	switch strings.ToLower(property) {
+	case "allow-origins":
+		l.Cors.AllowOrigins = strings.Fields(strings.ReplaceAll(value, ",", " "))
+	case "allow-methods":
+		l.Cors.AllowMethods = value
+	case "allow-headers":
+		l.Cors.AllowHeaders = value
+	case "expose-headers":
+		l.Cors.ExposeHeaders = value
+	case "max-age":
+		if maxAge, err := time.ParseDuration(value); err == nil {
+			l.Cors.MaxAge = maxAge
+		} else if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+			l.Cors.MaxAge = time.Duration(seconds) * time.Second
+		}
+	case "allow-credentials":
+		l.Cors.AllowCredentials, _ = strconv.ParseBool(value)
+	}
+}
+
+func (l *ServiceLabels) setFaultProperty(property, value string) {
+	switch strings.ToLower(property) {
+	case "delay":
+		if delay, err := time.ParseDuration(value); err == nil {
+			l.Fault.Delay = delay
+		}
+	case "delay-percent":
+		if v, err := strconv.ParseUint(value, 10, 32); err == nil {
+			percent := uint32(v)
+			l.Fault.DelayPercent = &percent
+		}
+	case "abort-status":
+		v, _ := strconv.ParseUint(value, 10, 32)
+		l.Fault.AbortStatus = uint32(v)
+	case "abort-percent":
+		if v, err := strconv.ParseUint(value, 10, 32); err == nil {
+			percent := uint32(v)
+			l.Fault.AbortPercent = &percent
+		}
+	}
+}
+
+func (l *ServiceLabels) setRateLimitProperty(property, value string) {
+	switch strings.ToLower(property) {
+	case "requests-per-unit":
+		v, _ := strconv.ParseUint(value, 10, 32)
+		l.RateLimit.RequestsPerUnit = uint32(v)
+	case "unit":
+		unit, err := parseRateLimitUnit(value)
+		if err != nil {
+			l.invalid = append(l.invalid, fmt.Errorf("the ratelimit.unit %q is invalid: %w", value, err))
+		}
+		l.RateLimit.Unit = unit
+	case "burst":
+		v, _ := strconv.ParseUint(value, 10, 32)
+		l.RateLimit.Burst = uint32(v)
+	case "response-headers":
+		l.RateLimit.ResponseHeaders = parseHeaderList(value)
+	}
+}
+
+/* Function parseRateLimitUnit:
+ * accepts second, minute, hour, or a duration such as 10s.
+ */
+func parseRateLimitUnit(value string) (time.Duration, error) {
+	switch strings.ToLower(value) {
+	case "second":
+		return time.Second, nil
+	case "minute":
+		return time.Minute, nil
+	case "hour":
+		return time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+/* Function parseHeaderList:
+ * parses "name=value,name=value" into headers; values may not contain
+ * commas.
+ */
+func parseHeaderList(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		name, v, ok := strings.Cut(item, "=")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			continue
+		}
+		headers[strings.ToLower(name)] = strings.TrimSpace(v)
+	}
+	return headers
+}
+
+/* Function parseNameList:
+ * parses "name,name" into lowercase header names.
+ */
+func parseNameList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	return names
+}
+
+func (l *ServiceLabels) setAccessLogProperty(property, value string) {
+	switch strings.ToLower(property) {
+	case "sink":
+		l.AccessLog.Sink = strings.ToLower(value)
+	case "path":
+		l.AccessLog.Path = value
+	case "format":
+		l.AccessLog.Format = value
+	}
+}
+
+func (l *ServiceLabels) setTracingProperty(property, value string) {
+	switch strings.ToLower(property) {
+	case "provider":
+		l.Tracing.Provider = strings.ToLower(value)
+	case "collector":
+		l.Tracing.CollectorHost, l.Tracing.CollectorPort = ParseHostPort(value)
+	case "sampling-percent":
+		if percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64); err == nil {
+			l.Tracing.SamplingPercent = &percent
+		}
+	case "tags":
+		l.Tracing.Tags = parseTagList(value)
+	}
+}
+
+/* Function ParseHostPort:
+ * splits "host[:port]", the port being 0 when not given or invalid.
+ */
+func ParseHostPort(value string) (string, uint32) {
+	host, p, err := net.SplitHostPort(value)
+	if err != nil {
+		return value, 0
+	}
+	port, _ := strconv.ParseUint(p, 10, 16)
+	return host, uint32(port)
+}
+
+/* Function parseTagList:
+ * parses "name=value,name=header:x-name" into span tags, keeping the
+ * case of the values.
+ */
+func parseTagList(value string) map[string]string {
+	tags := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		name, v, ok := strings.Cut(item, "=")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			continue
+		}
+		tags[name] = strings.TrimSpace(v)
 	}
+	return tags
+}
+
+/* Function setRuntimeProperty:
+ * keeps the runtime key as given, Envoy runtime keys being case-sensitive.
+ */
+func (l *ServiceLabels) setRuntimeProperty(property, value string) {
+	if l.Runtime == nil {
+		l.Runtime = make(map[string]string)
+	}
+	l.Runtime[property] = value
 }
 
 func (l ServiceLabels) Validate() error {
+	if len(l.invalid) > 0 {
+		return l.invalid[0]
+	}
+
 	if l.Listener.Port.PortValue <= 0 {
 		return errors.New("there is no listener.port label specified")
 	}
@@ -122,5 +436,106 @@ func (l ServiceLabels) Validate() error {
 		return errors.New("the endpoint.timeout can't be a negative number")
 	}
 
+	if faultPercent(l.Fault.DelayPercent) > 100 || faultPercent(l.Fault.AbortPercent) > 100 {
+		return errors.New("the fault.delay-percent and fault.abort-percent can't exceed 100")
+	}
+
+	if s := l.Fault.AbortStatus; s != 0 && (s < 200 || s > 599) {
+		return fmt.Errorf("the fault.abort-status %d is not an HTTP status", s)
+	}
+
+	for label, h := range map[string]configresource.HeaderMutation{
+		"request-headers":  l.Route.RequestHeaders,
+		"response-headers": l.Route.ResponseHeaders,
+	} {
+		if err := validateHeaderMutation(label, h); err != nil {
+			return err
+		}
+	}
+
+	if a := l.Auth; a.ExtAuthzHost == "" && !reflect.DeepEqual(a, ServiceAuth{}) {
+		return errors.New("there is no auth.ext-authz label specified")
+	}
+
+	if l.Auth.ExtAuthzHost != "" && l.Auth.ExtAuthzPort == 0 {
+		return errors.New("the auth.ext-authz port is invalid")
+	}
+
+	for _, path := range l.Auth.DisabledPaths {
+		if strings.HasSuffix(path, "/") {
+			return fmt.Errorf("the auth.disable-paths entry %s must not end with /", path)
+		}
+		if !strings.HasPrefix(path, l.Route.PathPrefix) {
+			return fmt.Errorf("the auth.disable-paths entry %s is not within route.path %s", path, l.Route.PathPrefix)
+		}
+	}
+
+	if c := l.Cors; len(c.AllowOrigins) == 0 && !reflect.DeepEqual(c, ServiceCors{}) {
+		return errors.New("there is no cors.allow-origins label specified")
+	}
+
+	for _, origin := range l.Cors.AllowOrigins {
+		if origin == "*" && l.Cors.AllowCredentials {
+			return errors.New("the cors.allow-origins wildcard can't be used with cors.allow-credentials")
+		}
+	}
+
+	if r := l.RateLimit; r.RequestsPerUnit == 0 && (r.Unit != 0 || r.Burst != 0 || len(r.ResponseHeaders) > 0) {
+		return errors.New("there is no ratelimit.requests-per-unit label specified")
+	}
+
+	switch l.AccessLog.Sink {
+	case "", configresource.AccessLogNone, configresource.AccessLogStdout, configresource.AccessLogFile, configresource.AccessLogGRPC:
+	default:
+		return fmt.Errorf("the accesslog.sink %s is not one of none, stdout, file and grpc", l.AccessLog.Sink)
+	}
+
+	if l.AccessLog.Path != "" && !strings.HasPrefix(l.AccessLog.Path, "/") {
+		return fmt.Errorf("the accesslog.path %s is not an absolute path", l.AccessLog.Path)
+	}
+
+	switch l.Tracing.Provider {
+	case "", configresource.TracingZipkin, configresource.TracingOpenTelemetry, configresource.TracingNone:
+	default:
+		return fmt.Errorf("the tracing.provider %s is not one of zipkin, opentelemetry and none", l.Tracing.Provider)
+	}
+
+	if p := l.Tracing.SamplingPercent; p != nil && (*p < 0 || *p > 100) {
+		return fmt.Errorf("the tracing.sampling-percent %g is outside [0, 100]", *p)
+	}
+
+	for name, value := range l.Tracing.Tags {
+		if header, ok := strings.CutPrefix(value, configresource.TracingHeaderTagPrefix); ok && !headerNameRegex.MatchString(strings.ToLower(header)) {
+			return fmt.Errorf("the tracing.tags entry %s reads the invalid header %q", name, header)
+		}
+	}
+
+	if l.RateLimit.Unit < 0 || (l.RateLimit.Unit > 0 && l.RateLimit.Unit < minRateLimitUnit) {
+		return fmt.Errorf("the ratelimit.unit must be at least %v", minRateLimitUnit)
+	}
+
+	return nil
+}
+
+/* Function validateHeaderMutation:
+ * rejects what Envoy would NACK: malformed names, and removing the Host
+ * or pseudo-headers.
+ */
+func validateHeaderMutation(label string, h configresource.HeaderMutation) error {
+	for suffix, headers := range map[string]map[string]string{"add": h.Add, "set": h.Set} {
+		for name := range headers {
+			if !headerNameRegex.MatchString(name) {
+				return fmt.Errorf("the route.%s-%s header name %q is invalid", label, suffix, name)
+			}
+			if name == "host" {
+				return fmt.Errorf("the route.%s-%s header %q can't be modified", label, suffix, name)
+			}
+		}
+	}
+	for _, name := range h.Remove {
+		if !headerNameRegex.MatchString(name) || name == "host" {
+			return fmt.Errorf("the route.%s-remove header %q can't be removed", label, name)
+		}
+	}
 	return nil
 }
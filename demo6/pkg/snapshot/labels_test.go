@@ -0,0 +1,159 @@
+package snapshot
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Labels of a minimal valid service, extended by each test case
+func baseLabels(extra map[string]string) map[string]string {
+	labels := map[string]string{
+		"envoy.status.node-id":      "local_node_1",
+		"envoy.listener.port":       "10000",
+		"envoy.endpoint.port":       "8080",
+		"envoy.route.path":          "/api",
+		"envoy.route.upstream-host": "app-1",
+	}
+	for k, v := range extra {
+		labels[k] = v
+	}
+	return labels
+}
+
+func TestServiceLabels_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		succeeded bool
+	}{
+		{"minimal", baseLabels(nil), true},
+		{"no listener port", map[string]string{"envoy.status.node-id": "n", "envoy.endpoint.port": "80"}, false},
+
+		{"rate limit", baseLabels(map[string]string{
+			"envoy.ratelimit.requests-per-unit": "100", "envoy.ratelimit.unit": "minute", "envoy.ratelimit.burst": "20",
+		}), true},
+		{"rate limit duration unit", baseLabels(map[string]string{
+			"envoy.ratelimit.requests-per-unit": "100", "envoy.ratelimit.unit": "50ms",
+		}), true},
+		{"rate limit unit misspelled", baseLabels(map[string]string{
+			"envoy.ratelimit.requests-per-unit": "100", "envoy.ratelimit.unit": "minutes",
+		}), false},
+		{"rate limit unit too short", baseLabels(map[string]string{
+			"envoy.ratelimit.requests-per-unit": "100", "envoy.ratelimit.unit": "10ms",
+		}), false},
+		{"rate limit without requests", baseLabels(map[string]string{"envoy.ratelimit.burst": "20"}), false},
+
+		{"cors", baseLabels(map[string]string{
+			"envoy.cors.allow-origins": "https://app.example.com", "envoy.cors.allow-credentials": "true",
+		}), true},
+		{"cors wildcard", baseLabels(map[string]string{"envoy.cors.allow-origins": "*"}), true},
+		{"cors wildcard with credentials", baseLabels(map[string]string{
+			"envoy.cors.allow-origins": "*", "envoy.cors.allow-credentials": "true",
+		}), false},
+		{"cors without origins", baseLabels(map[string]string{"envoy.cors.max-age": "1h"}), false},
+
+		{"auth", baseLabels(map[string]string{
+			"envoy.auth.ext-authz": "auth-server:4040", "envoy.auth.disable-paths": "/api/healthz,/api/public",
+		}), true},
+		{"auth disabled path with trailing slash", baseLabels(map[string]string{
+			"envoy.auth.ext-authz": "auth-server", "envoy.auth.disable-paths": "/api/public/",
+		}), false},
+		{"auth disabled path outside route", baseLabels(map[string]string{
+			"envoy.auth.ext-authz": "auth-server", "envoy.auth.disable-paths": "/healthz",
+		}), false},
+		{"auth without server", baseLabels(map[string]string{"envoy.auth.timeout": "250ms"}), false},
+
+		{"header rewrite", baseLabels(map[string]string{"envoy.route.request-headers-set": "x-real-ip=%DOWNSTREAM_REMOTE_ADDRESS%"}), true},
+		{"host header set", baseLabels(map[string]string{"envoy.route.request-headers-set": "host=example.com"}), false},
+		{"host header removed", baseLabels(map[string]string{"envoy.route.request-headers-remove": "host"}), false},
+
+		{"tracing", baseLabels(map[string]string{
+			"envoy.tracing.provider": "zipkin", "envoy.tracing.collector": "zipkin:9411", "envoy.tracing.sampling-percent": "0",
+		}), true},
+		{"tracing opt out", baseLabels(map[string]string{"envoy.tracing.provider": "none"}), true},
+		{"tracing unknown provider", baseLabels(map[string]string{"envoy.tracing.provider": "jaeger"}), false},
+		{"tracing sampling above 100", baseLabels(map[string]string{"envoy.tracing.sampling-percent": "150"}), false},
+
+		{"fault", baseLabels(map[string]string{
+			"envoy.fault.abort-status": "503", "envoy.fault.abort-percent": "0", "envoy.fault.delay": "2s",
+		}), true},
+		{"fault percent above 100", baseLabels(map[string]string{
+			"envoy.fault.delay": "2s", "envoy.fault.delay-percent": "101",
+		}), false},
+		{"fault status", baseLabels(map[string]string{"envoy.fault.abort-status": "99"}), false},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ParseServiceLabels(test.labels).Validate()
+			if (err == nil) != test.succeeded {
+				t.Errorf("ServiceLabels.Validate() error: %v, succeeded: %v", err, test.succeeded)
+			}
+		})
+	}
+}
+
+func TestParseServiceLabels(t *testing.T) {
+	t.Parallel()
+
+	percent := func(p uint32) *uint32 { return &p }
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		check  func(l *ServiceLabels) interface{}
+		want   interface{}
+	}{
+		{"rate limit unit", baseLabels(map[string]string{"envoy.ratelimit.unit": "hour"}),
+			func(l *ServiceLabels) interface{} { return l.RateLimit.Unit }, time.Hour},
+		{"fault percent unset", baseLabels(map[string]string{"envoy.fault.delay": "1s"}),
+			func(l *ServiceLabels) interface{} { return l.Fault.DelayPercent }, (*uint32)(nil)},
+		{"fault percent zero", baseLabels(map[string]string{"envoy.fault.abort-percent": "0"}),
+			func(l *ServiceLabels) interface{} { return l.Fault.AbortPercent }, percent(0)},
+		{"disabled paths", baseLabels(map[string]string{"envoy.auth.disable-paths": "api/healthz, /api/public"}),
+			func(l *ServiceLabels) interface{} { return l.Auth.DisabledPaths }, []string{"/api/healthz", "/api/public"}},
+		{"cors origins", baseLabels(map[string]string{"envoy.cors.allow-origins": "https://a.example, https://b.example"}),
+			func(l *ServiceLabels) interface{} { return l.Cors.AllowOrigins }, []string{"https://a.example", "https://b.example"}},
+		{"tracing tags", baseLabels(map[string]string{"envoy.tracing.tags": "team=payments,ua=header:User-Agent"}),
+			func(l *ServiceLabels) interface{} { return l.Tracing.Tags }, map[string]string{"team": "payments", "ua": "header:User-Agent"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := test.check(ParseServiceLabels(test.labels)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseServiceLabels() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRuntimeValue(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value string
+		want  interface{}
+	}{
+		{"500", float64(500)},
+		{"0.25", 0.25},
+		{"true", true},
+		{"FALSE", false},
+		{"enabled", "enabled"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := parseRuntimeValue(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseRuntimeValue(%q) = %#v, want %#v", test.value, got, test.want)
+		}
+	}
+}
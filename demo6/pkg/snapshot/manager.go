@@ -3,95 +3,170 @@ package snapshot
 import (
 	"context"
 	"fmt"
-	"os"
 	"reflect"
+	"sync"
 	"time"
 
-	"envoy-swarm-control/pkg/configresource"
+	"envoy-swarm-control/pkg/audit"
+	"envoy-swarm-control/pkg/election"
+	"envoy-swarm-control/pkg/metrics"
+	"envoy-swarm-control/pkg/store"
+	"envoy-swarm-control/pkg/xdstypes"
 
 	"github.com/sirupsen/logrus"
 
-	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
-	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
 )
 
 type Manager struct {
-	snapshotCache                        cache.SnapshotCache
-	clusters, listeners, routes, secrets []types.Resource
+	snapshotCache cache.SnapshotCache
+	store         store.Store // optional, nil keeps everything in memory
+	audit         *audit.Log  // optional, records every snapshot change
+	elector       election.Elector
+
+	publishMu sync.Mutex // serializes every read-modify-write of the snapshot cache
+
+	mu       sync.Mutex
+	acked    map[string]map[string]cache.Resources // node ID -> type URL -> last ACKed resources
+	rejected map[string]map[string]string          // node ID -> type URL -> last NACKed version
+	services map[string]*ServiceHealth             // service name -> outcome of its last update
+	runtime  *RuntimeFile                          // runtime values of every node, besides labels
 }
 
-func NewManager(config cache.SnapshotCache) *Manager {
+func NewManager(config cache.SnapshotCache, st store.Store, auditLog *audit.Log) *Manager {
 	return &Manager{
 		snapshotCache: config,
-		clusters:      []types.Resource{},
-		listeners:     []types.Resource{},
-		routes:        []types.Resource{},
-		secrets:       []types.Resource{},
+		store:         st,
+		audit:         auditLog,
+		acked:         make(map[string]map[string]cache.Resources),
+		rejected:      make(map[string]map[string]string),
+		services:      make(map[string]*ServiceHealth),
 	}
 }
 
+/* Function SetElector:
+ * tells the manager whether its replica leads. Without an elector it
+ * always does.
+ */
+func (m *Manager) SetElector(elector election.Elector) {
+	m.elector = elector
+}
+
+func (m *Manager) isLeader() bool {
+	return m.elector == nil || m.elector.IsLeader()
+}
+
 /* Function Discover:
  * just a wrapper around updateConfiguration.
  */
 func (m *Manager) Discover(updateChannel chan ServiceLabels, ctx context.Context) {
 	for {
-		update := <-updateChannel
+		var update ServiceLabels
+		select {
+		case <-ctx.Done():
+			return
+		case update = <-updateChannel:
+		}
 		if reflect.DeepEqual(update, ServiceLabels{}) {
 			continue
 		}
 
-		m.updateConfiguration(update, ctx)
+		if m.isQuarantined(update) {
+			logrus.Warnf("Service %s is quarantined, ignoring update until its labels change", update.serviceKey())
+			continue
+		}
+
+		start := time.Now()
+		err := m.updateConfiguration(update, ctx)
+		metrics.SnapshotBuildDuration.WithLabelValues(update.Status.NodeID).Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.SnapshotUpdates.WithLabelValues(update.Status.NodeID, "failed").Inc()
+			m.quarantine(update, err)
+			m.persistService(update.serviceKey())
+			continue
+		}
+		m.release(update)
+		m.persistService(update.serviceKey())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
+
+func (m *Manager) updateConfiguration(update ServiceLabels, ctx context.Context) error {
+	logrus.Infof(">>>>>>>>>>>>>>>>>>> creating snapshot for nodeID %s", update.Status.NodeID)
+
+	resources, err := buildResources(update, m.runtimeFile())
+	if err != nil {
+		return err
+	}
 
-		time.Sleep(30 * time.Second)
+	// Every type is versioned by a hash of its content
+	snap, err := newSnapshot(resources)
+	if err != nil {
+		return fmt.Errorf("snapshot versioning: %w", err)
 	}
+
+	m.publishMu.Lock()
+	defer m.publishMu.Unlock()
+
+	current, _ := m.snapshotCache.GetSnapshot(update.Status.NodeID)
+	if current != nil && reflect.DeepEqual(snapshotVersions(current), snapshotVersions(snap)) {
+		logrus.Infof("Configuration of node %s is unchanged, skipping snapshot", update.Status.NodeID)
+		metrics.SnapshotUpdates.WithLabelValues(update.Status.NodeID, "unchanged").Inc()
+		return nil
+	}
+
+	if err := m.publish(ctx, update.Status.NodeID, update.serviceKey(), snap, current, "published", update.Event); err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"versions": snapshotVersions(snap)}).Infof("Snapshot served: %+v", snap)
+	return nil
 }
 
-func (m *Manager) updateConfiguration(update ServiceLabels, ctx context.Context) {
-	version := time.Now().Format(time.RFC3339) // timestamp as version number
-	logrus.Infof(">>>>>>>>>>>>>>>>>>> creating snapshot " + fmt.Sprint(version) + " for nodeID " + fmt.Sprint(update.Status.NodeID))
-
-	cluster := configresource.ProvideCluster(
-		fmt.Sprintf("%s_cluster", update.Status.NodeID),
-		update.Route.UpstreamHost,
-		update.Endpoint.Port.PortValue,
-	)
-	listener := configresource.ProvideHTTPListener(
-		fmt.Sprintf("%s_listener", update.Status.NodeID),
-		fmt.Sprintf("%s_route", update.Status.NodeID),
-		update.Listener.Port.PortValue,
-	)
-	route := configresource.ProvideRoute(
-		fmt.Sprintf("%s_route", update.Status.NodeID),
-		fmt.Sprintf("%s_service", update.Status.NodeID),
-		fmt.Sprintf("%s_cluster", update.Status.NodeID),
-		update.Route.UpstreamHost,
-		update.Route.PathPrefix,
-		update.Endpoint.RequestTimeout,
-	)
-	secret := configresource.ProvideSecret()
-	if secret != nil {
-		m.secrets = append(m.secrets, secret)
+/* Function publish:
+ * validates a snapshot and serves it to a node in place of current,
+ * unless the node already rejected one of the versions that change.
+ * Callers hold publishMu from reading current on.
+ */
+func (m *Manager) publish(ctx context.Context, nodeID, service string, snap *cache.Snapshot, current cache.ResourceSnapshot, trigger, event string) error {
+	for _, typ := range xdstypes.ResourceTypes {
+		version := snap.GetVersion(typ)
+		if current != nil && current.GetVersion(typ) == version {
+			continue
+		}
+		if m.isRejected(nodeID, typ, version) {
+			return fmt.Errorf("node %s already rejected %s version %s", nodeID, typ, version)
+		}
 	}
-	m.clusters = append(m.clusters, cluster)
-	m.listeners = append(m.listeners, listener)
-	m.routes = append(m.routes, route)
 
-	resources := make(map[string][]types.Resource, 4)
-	resources[resource.ClusterType] = []types.Resource{cluster}
-	resources[resource.RouteType] = []types.Resource{route}
-	resources[resource.ListenerType] = []types.Resource{listener}
-	resources[resource.SecretType] = []types.Resource{secret}
+	if err := ValidateSnapshot(service, snap); err != nil {
+		return err
+	}
 
-	snap, _ := cache.NewSnapshot(fmt.Sprint(version), resources)
 	if err := snap.Consistent(); err != nil {
-		logrus.Errorf("Snapshot inconsistency: %+v\n%+v", snap, err)
-		os.Exit(1)
+		return fmt.Errorf("snapshot inconsistency: %w", err)
 	}
 
-	if err := m.snapshotCache.SetSnapshot(ctx, update.Status.NodeID, snap); err != nil {
-		logrus.Fatalf("Snapshot error %q for %+v", err, snap)
+	if err := m.snapshotCache.SetSnapshot(ctx, nodeID, snap); err != nil {
+		return fmt.Errorf("setting snapshot: %w", err)
 	}
+	recordPublished(nodeID, snap, trigger)
+	m.auditChange(nodeID, service, current, trigger, event)
+	m.persistSnapshot(nodeID, snap)
+	return nil
+}
 
-	logrus.Infof("Snapshot served: %+v", snap)
+/* Function recordPublished:
+ * exports the versions now served to a node.
+ */
+func recordPublished(nodeID string, snap cache.ResourceSnapshot, result string) {
+	metrics.SnapshotUpdates.WithLabelValues(nodeID, result).Inc()
+	for _, typ := range xdstypes.ResourceTypes {
+		metrics.SetSnapshotVersion(nodeID, typ, snap.GetVersion(typ))
+	}
 }
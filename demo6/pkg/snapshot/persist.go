@@ -0,0 +1,186 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"envoy-swarm-control/pkg/election"
+	"envoy-swarm-control/pkg/xdstypes"
+
+	"github.com/sirupsen/logrus"
+
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+/* Function Restore:
+ * loads the persisted service model and snapshots into the manager and the
+ * snapshot cache. Must run before the xDS server accepts streams, so that
+ * reconnecting Envoys are served their last configuration right away.
+ */
+func (m *Manager) Restore(ctx context.Context) error {
+	if m.store == nil {
+		return nil
+	}
+
+	services, err := m.store.LoadServices()
+	if err != nil {
+		return err
+	}
+	for name, data := range services {
+		var h ServiceHealth
+		if err := json.Unmarshal(data, &h); err != nil {
+			logrus.Warnf("Skipping unreadable persisted service %s: %v", name, err)
+			continue
+		}
+		m.mu.Lock()
+		m.services[name] = &h
+		m.mu.Unlock()
+	}
+
+	snapshots, err := m.store.LoadSnapshots()
+	if err != nil {
+		return err
+	}
+	for nodeID, snap := range snapshots {
+		if err := m.restoreSnapshot(ctx, nodeID, snap); err != nil {
+			logrus.Errorf("Restoring snapshot of node %s: %v", nodeID, err)
+		}
+	}
+
+	logrus.Debugf("Restored %d service(s) and %d snapshot(s)", len(services), len(snapshots))
+	return nil
+}
+
+func (m *Manager) restoreSnapshot(ctx context.Context, nodeID string, snap *cache.Snapshot) error {
+	m.publishMu.Lock()
+	defer m.publishMu.Unlock()
+
+	current, _ := m.snapshotCache.GetSnapshot(nodeID)
+	if current != nil && reflect.DeepEqual(snapshotVersions(current), snapshotVersions(snap)) {
+		return nil
+	}
+	if err := snap.Consistent(); err != nil {
+		logrus.Warnf("Skipping inconsistent persisted snapshot of node %s: %v", nodeID, err)
+		return nil
+	}
+	if err := m.snapshotCache.SetSnapshot(ctx, nodeID, snap); err != nil {
+		return err
+	}
+	recordPublished(nodeID, snap, "restored")
+	m.auditChange(nodeID, "", current, "restored", "")
+	m.restoreAcked(nodeID, snap)
+	return nil
+}
+
+/* Function restoreAcked:
+ * takes the restored snapshot as the last configuration accepted by the
+ * node for every type it has no ACK or rejection of, so that a NACK has
+ * something to roll back to. A reconnecting Envoy already running these
+ * versions is not sent them again, hence never ACKs them.
+ */
+func (m *Manager) restoreAcked(nodeID string, snap *cache.Snapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.acked[nodeID] == nil {
+		m.acked[nodeID] = make(map[string]cache.Resources)
+	}
+	for _, typ := range xdstypes.ResourceTypes {
+		if _, ok := m.acked[nodeID][typ]; ok {
+			continue
+		}
+		if version := snap.GetVersion(typ); version == "" || m.rejected[nodeID][typ] == version {
+			continue
+		}
+		m.acked[nodeID][typ] = resourcesOf(snap, typ)
+	}
+}
+
+/* Function Follow:
+ * keeps a standby replica in sync with the state persisted by the leader,
+ * so that it serves the same snapshots and can take over at any time.
+ */
+func (m *Manager) Follow(ctx context.Context, elector election.Elector, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if elector.IsLeader() {
+			continue
+		}
+		if err := m.Restore(ctx); err != nil {
+			logrus.Errorf("Standby sync: %v", err)
+		}
+	}
+}
+
+/* Function Flush:
+ * persists every service and the snapshot currently served to its node.
+ * Called on shutdown once Discover has returned.
+ */
+func (m *Manager) Flush() {
+	if m.store == nil {
+		return
+	}
+
+	m.mu.Lock()
+	names := make([]string, 0, len(m.services))
+	nodes := make(map[string]bool)
+	for name, h := range m.services {
+		names = append(names, name)
+		nodes[h.NodeID] = true
+	}
+	m.mu.Unlock()
+
+	for _, name := range names {
+		m.persistService(name)
+	}
+	for nodeID := range nodes {
+		if snap, err := m.snapshotCache.GetSnapshot(nodeID); err == nil {
+			m.persistSnapshot(nodeID, snap)
+		}
+	}
+	logrus.Debugf("Flushed %d service(s) and %d node(s)", len(names), len(nodes))
+}
+
+func (m *Manager) persistService(name string) {
+	if m.store == nil {
+		return
+	}
+
+	m.mu.Lock()
+	h, ok := m.services[name]
+	var data []byte
+	var err error
+	if ok {
+		data, err = json.Marshal(h)
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if err == nil {
+		err = m.store.SaveService(name, data)
+	}
+	if err != nil {
+		logrus.Errorf("Persisting service %s: %v", name, err)
+	}
+}
+
+func (m *Manager) persistSnapshot(nodeID string, snap cache.ResourceSnapshot) {
+	if m.store == nil {
+		return
+	}
+	if err := m.store.SaveSnapshot(nodeID, snap); err != nil {
+		logrus.Errorf("Persisting snapshot of node %s: %v", nodeID, err)
+	}
+}
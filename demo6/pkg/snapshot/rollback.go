@@ -0,0 +1,154 @@
+package snapshot
+
+import (
+	"context"
+
+	"envoy-swarm-control/pkg/callback"
+	"envoy-swarm-control/pkg/xdstypes"
+
+	"github.com/sirupsen/logrus"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+var _ callback.AckHandler = &Manager{}
+
+/* Interface versionMapper:
+ * implemented by caches whose responses carry versions of their own, e.g.,
+ * the linear caches of Delta xDS, to map them back to snapshot versions.
+ */
+type versionMapper interface {
+	ContentVersion(nodeID, typeURL, version string) string
+}
+
+/* Function snapshotVersion:
+ * returns the snapshot version of a version sent to a node.
+ */
+func (m *Manager) snapshotVersion(nodeID, typeURL, version string) string {
+	if mapper, ok := m.snapshotCache.(versionMapper); ok {
+		if v := mapper.ContentVersion(nodeID, typeURL, version); v != "" {
+			return v
+		}
+	}
+	return version
+}
+
+/* Function OnAck:
+ * remembers the resources of the given type as the last configuration
+ * accepted by the node, provided the ACK is for the version currently
+ * held in the snapshot cache.
+ */
+func (m *Manager) OnAck(nodeID, typeURL, version string) {
+	version = m.snapshotVersion(nodeID, typeURL, version)
+	m.auditAck(nodeID, AckRecord{TypeURL: typeURL, Version: version, Acked: true})
+
+	snap, err := m.snapshotCache.GetSnapshot(nodeID)
+	if err != nil || snap.GetVersion(typeURL) != version {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.acked[nodeID] == nil {
+		m.acked[nodeID] = make(map[string]cache.Resources)
+	}
+	m.acked[nodeID][typeURL] = resourcesOf(snap, typeURL)
+}
+
+/* Function OnNack:
+ * marks the rejected version as bad and restores the node's last ACKed
+ * snapshot, so the cache stops offering the rejected configuration. A
+ * standby only records the rejection: rolling back is up to the leader,
+ * the only replica writing snapshots.
+ */
+func (m *Manager) OnNack(nodeID, typeURL, version string, detail *rpcstatus.Status) {
+	version = m.snapshotVersion(nodeID, typeURL, version)
+	logrus.WithFields(logrus.Fields{
+		"node":    nodeID,
+		"type":    typeURL,
+		"version": version,
+	}).Errorf("Envoy rejected configuration: %s", detail.GetMessage())
+	m.auditAck(nodeID, AckRecord{TypeURL: typeURL, Version: version, Error: detail.GetMessage()})
+
+	m.mu.Lock()
+	if m.rejected[nodeID] == nil {
+		m.rejected[nodeID] = make(map[string]string)
+	}
+	m.rejected[nodeID][typeURL] = version
+
+	if !m.isLeader() {
+		m.mu.Unlock()
+		logrus.Infof("Standing by, leaving the rollback of node %s to the leader", nodeID)
+		return
+	}
+
+	// Copied, as OnAck keeps writing to the map of the node once unlocked
+	acked := make(map[string]cache.Resources, len(m.acked[nodeID]))
+	for typ, res := range m.acked[nodeID] {
+		acked[typ] = res
+	}
+	m.mu.Unlock()
+	if len(acked) == 0 {
+		logrus.Warnf("No ACKed snapshot for node %s, nothing to roll back to", nodeID)
+		return
+	}
+
+	m.publishMu.Lock()
+	defer m.publishMu.Unlock()
+
+	// A late NACK must not replace a snapshot published since
+	current, _ := m.snapshotCache.GetSnapshot(nodeID)
+	if current == nil || current.GetVersion(typeURL) != version {
+		logrus.Infof("Node %s is no longer served the rejected %s version %s, not rolling back", nodeID, typeURL, version)
+		return
+	}
+
+	snap := &cache.Snapshot{}
+	for typ, res := range acked {
+		snap.Resources[cache.GetResponseType(typ)] = res
+	}
+	// Types the node never ACKed keep whatever is currently served, except the rejected one
+	for _, typ := range xdstypes.ResourceTypes {
+		if _, ok := acked[typ]; ok || typ == typeURL {
+			continue
+		}
+		snap.Resources[cache.GetResponseType(typ)] = resourcesOf(current, typ)
+	}
+
+	if err := snap.Consistent(); err != nil {
+		logrus.Errorf("Rollback of node %s failed: snapshot inconsistency: %v", nodeID, err)
+		return
+	}
+	if err := m.snapshotCache.SetSnapshot(context.Background(), nodeID, snap); err != nil {
+		logrus.Errorf("Rollback of node %s failed: %v", nodeID, err)
+		return
+	}
+	recordPublished(nodeID, snap, "rollback")
+	m.auditChange(nodeID, "", current, "rollback", "")
+	m.persistSnapshot(nodeID, snap)
+	logrus.Infof("Rolled back node %s to last ACKed %s version %s", nodeID, typeURL, acked[typeURL].Version)
+}
+
+/* Function isRejected:
+ * reports whether the node has already NACKed this version of a type.
+ */
+func (m *Manager) isRejected(nodeID, typeURL, version string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rejected[nodeID][typeURL] == version
+}
+
+func resourcesOf(snap cache.ResourceSnapshot, typeURL string) cache.Resources {
+	items := snap.GetResourcesAndTTL(typeURL)
+	res := cache.Resources{
+		Version: snap.GetVersion(typeURL),
+		Items:   make(map[string]types.ResourceWithTTL, len(items)),
+	}
+	for name, item := range items {
+		res.Items[name] = item
+	}
+	return res
+}
@@ -0,0 +1,211 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"envoy-swarm-control/pkg/configresource"
+	"envoy-swarm-control/pkg/xdstypes"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gopkg.in/yaml.v3"
+
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+/* Structure RuntimeFile:
+ * runtime values served over RTDS, to every node and per node ID. Values
+ * are numbers, booleans, strings, or maps such as fractional percents:
+ *
+ *   all:
+ *     overload.global_downstream_max_connections: 1000
+ *   nodes:
+ *     local_node_1:
+ *       feature.new_checkout: {numerator: 10, denominator: HUNDRED}
+ */
+type RuntimeFile struct {
+	All   map[string]interface{}            `yaml:"all"`
+	Nodes map[string]map[string]interface{} `yaml:"nodes"`
+}
+
+func LoadRuntimeFile(path string) (*RuntimeFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r RuntimeFile
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&r); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	// A value RTDS cannot carry would fail the snapshot of every service
+	if _, err := structpb.NewStruct(r.All); err != nil {
+		return nil, fmt.Errorf("%s: all: %w", path, err)
+	}
+	for nodeID, values := range r.Nodes {
+		if _, err := structpb.NewStruct(values); err != nil {
+			return nil, fmt.Errorf("%s: nodes: %s: %w", path, nodeID, err)
+		}
+	}
+	return &r, nil
+}
+
+/* Function Layer:
+ * merges the values of a node: the file's values for all nodes, then
+ * those for the node, then the envoy.runtime.<key> labels of its service.
+ */
+func (r *RuntimeFile) Layer(nodeID string, labels map[string]string) map[string]interface{} {
+	values := make(map[string]interface{})
+	if r != nil {
+		for key, v := range r.All {
+			values[key] = v
+		}
+		for key, v := range r.Nodes[nodeID] {
+			values[key] = v
+		}
+	}
+	for key, v := range labels {
+		values[key] = parseRuntimeValue(v)
+	}
+	return values
+}
+
+/* Function parseRuntimeValue:
+ * types a label value the way Envoy reads runtime values: numbers for
+ * integers and percentages, booleans for feature flags, else strings.
+ */
+func parseRuntimeValue(s string) interface{} {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	switch strings.ToLower(s) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return s
+}
+
+func (m *Manager) SetRuntimeFile(r *RuntimeFile) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runtime = r
+}
+
+func (m *Manager) runtimeFile() *RuntimeFile {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.runtime
+}
+
+/* Function WatchRuntimeFile:
+ * reloads the runtime file right away, as it may have changed while this
+ * replica was standing by, and then whenever it changes, pushing the new
+ * layer to every node without touching their other resources. Returns
+ * when ctx is done; does nothing without a file.
+ */
+func (m *Manager) WatchRuntimeFile(ctx context.Context, path string, interval time.Duration) {
+	if path == "" {
+		return
+	}
+	var lastMod time.Time
+	if fi, err := os.Stat(path); err == nil {
+		lastMod = fi.ModTime()
+	}
+	if r, err := LoadRuntimeFile(path); err != nil {
+		logrus.Errorf("Keeping the previous runtime values: %v", err)
+	} else {
+		m.SetRuntimeFile(r)
+		m.publishRuntime(ctx)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			logrus.Errorf("Runtime file: %v", err)
+			continue
+		}
+		if fi.ModTime().Equal(lastMod) {
+			continue
+		}
+		lastMod = fi.ModTime()
+
+		r, err := LoadRuntimeFile(path)
+		if err != nil {
+			logrus.Errorf("Keeping the previous runtime values: %v", err)
+			continue
+		}
+		m.SetRuntimeFile(r)
+		logrus.Infof("Runtime file %s changed, updating the runtime layer of every node", path)
+		m.publishRuntime(ctx)
+	}
+}
+
+/* Function publishRuntime:
+ * replaces the runtime layer in the snapshot of every node configured by
+ * a service that is not quarantined, through the same checks as any
+ * other update.
+ */
+func (m *Manager) publishRuntime(ctx context.Context) {
+	for _, h := range m.Services() {
+		if h.Quarantined {
+			continue
+		}
+		if err := m.publishRuntimeLayer(ctx, h); err != nil {
+			logrus.Errorf("Runtime layer of node %s: %v", h.NodeID, err)
+		}
+	}
+}
+
+func (m *Manager) publishRuntimeLayer(ctx context.Context, h ServiceHealth) error {
+	layer, err := configresource.ProvideRuntime(m.runtimeFile().Layer(h.NodeID, h.Labels.Runtime))
+	if err != nil {
+		return err
+	}
+	items := []types.Resource{layer}
+	version, err := resourceVersion(items)
+	if err != nil {
+		return err
+	}
+
+	m.publishMu.Lock()
+	defer m.publishMu.Unlock()
+
+	current, err := m.snapshotCache.GetSnapshot(h.NodeID)
+	if err != nil {
+		return nil // not served yet, the layer comes with its first snapshot
+	}
+	if old, ok := current.GetResources(resource.RuntimeType)[layer.Name]; ok && proto.Equal(old, layer) {
+		return nil
+	}
+
+	snap := &cache.Snapshot{}
+	for _, typ := range xdstypes.ResourceTypes {
+		snap.Resources[cache.GetResponseType(typ)] = resourcesOf(current, typ)
+	}
+	snap.Resources[cache.GetResponseType(resource.RuntimeType)] = cache.NewResources(version, items)
+	return m.publish(ctx, h.NodeID, h.Service, snap, current, "runtime", "")
+}
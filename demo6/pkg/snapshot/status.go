@@ -0,0 +1,97 @@
+package snapshot
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/* Structure ServiceHealth:
+ * the outcome of the last configuration update triggered by a service.
+ * A quarantined service is ignored until its labels change.
+ */
+type ServiceHealth struct {
+	Service          string
+	NodeID           string
+	Labels           ServiceLabels // labels of the last update
+	Quarantined      bool
+	Error            string
+	ValidationErrors ValidationErrors `json:",omitempty"`
+	UpdatedAt        time.Time
+}
+
+/* Function Services:
+ * returns the status of every service seen by the manager, sorted by name.
+ */
+func (m *Manager) Services() []ServiceHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]ServiceHealth, 0, len(m.services))
+	for _, h := range m.services {
+		out = append(out, *h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out
+}
+
+func (m *Manager) quarantine(update ServiceLabels, err error) {
+	logrus.WithFields(logrus.Fields{
+		"service": update.serviceKey(),
+		"node":    update.Status.NodeID,
+	}).Errorf("Quarantining service: %v", err)
+
+	var verrs ValidationErrors
+	errors.As(err, &verrs)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.services[update.serviceKey()] = &ServiceHealth{
+		Service:          update.serviceKey(),
+		NodeID:           update.Status.NodeID,
+		Labels:           update,
+		Quarantined:      true,
+		Error:            err.Error(),
+		ValidationErrors: verrs,
+		UpdatedAt:        time.Now(),
+	}
+}
+
+func (m *Manager) release(update ServiceLabels) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.services[update.serviceKey()] = &ServiceHealth{
+		Service:   update.serviceKey(),
+		NodeID:    update.Status.NodeID,
+		Labels:    update,
+		UpdatedAt: time.Now(),
+	}
+}
+
+/* Function isQuarantined:
+ * reports whether the service is quarantined with exactly these labels.
+ */
+func (m *Manager) isQuarantined(update ServiceLabels) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.services[update.serviceKey()]
+	if !ok || !h.Quarantined {
+		return false
+	}
+	update.Event = h.Labels.Event // same labels, whichever event delivered them
+	return reflect.DeepEqual(h.Labels, update)
+}
+
+/* Function serviceKey:
+ * identifies the service behind an update, falling back to the node ID
+ * when the watcher did not know the service name.
+ */
+func (l ServiceLabels) serviceKey() string {
+	if l.ServiceName != "" {
+		return l.ServiceName
+	}
+	return l.Status.NodeID
+}
@@ -0,0 +1,391 @@
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+
+	"envoy-swarm-control/pkg/xdstypes"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+)
+
+/* Structure ValidationError:
+ * a single invalid field or dangling reference in a generated resource,
+ * traced back to the service and label it most likely came from.
+ */
+type ValidationError struct {
+	Service  string
+	Label    string // e.g., envoy.listener.port
+	TypeURL  string
+	Resource string
+	Field    string // dotted path of the offending field, if known
+	Reason   string
+}
+
+func (e ValidationError) Error() string {
+	field := ""
+	if e.Field != "" {
+		field = "." + e.Field
+	}
+	return fmt.Sprintf("service %s, label %s: %s %s%s: %s",
+		e.Service, e.Label, shortTypeName(e.TypeURL), e.Resource, field, e.Reason)
+}
+
+/* Type ValidationErrors:
+ * all problems found in one snapshot.
+ */
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	return fmt.Sprintf("%d validation error(s): %s", len(errs), strings.Join(msgs, "; "))
+}
+
+/* Function ValidateSnapshot:
+ * runs the generated proto validators over every resource in the snapshot
+ * and checks cross references: routes must point at existing clusters and
+ * listeners must reference existing route configurations over RDS.
+ */
+func ValidateSnapshot(service string, snap cache.ResourceSnapshot) error {
+	var errs ValidationErrors
+
+	for _, typ := range xdstypes.ResourceTypes {
+		for name, res := range snap.GetResources(typ) {
+			for _, err := range validateResource(res) {
+				field, reason := splitValidationError(err)
+				errs = append(errs, ValidationError{
+					Service:  service,
+					Label:    blameLabel(typ, field),
+					TypeURL:  typ,
+					Resource: name,
+					Field:    field,
+					Reason:   reason,
+				})
+			}
+		}
+	}
+
+	clusters := snap.GetResources(resource.ClusterType)
+	for name, res := range snap.GetResources(resource.RouteType) {
+		for _, ref := range routeClusterReferences(res.(*route.RouteConfiguration)) {
+			if _, ok := clusters[ref]; !ok {
+				errs = append(errs, ValidationError{
+					Service:  service,
+					Label:    "envoy.status.node-id",
+					TypeURL:  resource.RouteType,
+					Resource: name,
+					Reason:   fmt.Sprintf("references unknown cluster %q", ref),
+				})
+			}
+		}
+	}
+
+	routes := snap.GetResources(resource.RouteType)
+	for name, res := range snap.GetResources(resource.ListenerType) {
+		refs, err := listenerRouteReferences(res.(*listener.Listener))
+		if err != nil {
+			errs = append(errs, ValidationError{
+				Service:  service,
+				Label:    "envoy.listener.port",
+				TypeURL:  resource.ListenerType,
+				Resource: name,
+				Reason:   err.Error(),
+			})
+		}
+		for _, ref := range refs {
+			if _, ok := routes[ref]; !ok {
+				errs = append(errs, ValidationError{
+					Service:  service,
+					Label:    "envoy.status.node-id",
+					TypeURL:  resource.ListenerType,
+					Resource: name,
+					Reason:   fmt.Sprintf("references unknown route configuration %q", ref),
+				})
+			}
+		}
+	}
+
+	extensions := snap.GetResources(resource.ExtensionConfigType)
+	for name, res := range snap.GetResources(resource.ListenerType) {
+		refs, err := listenerExtensionReferences(res.(*listener.Listener))
+		if err != nil {
+			continue // already reported with the route references
+		}
+		for _, ref := range refs {
+			if _, ok := extensions[ref]; !ok {
+				errs = append(errs, ValidationError{
+					Service:  service,
+					Label:    "envoy.status.node-id",
+					TypeURL:  resource.ListenerType,
+					Resource: name,
+					Reason:   fmt.Sprintf("references unknown extension config %q", ref),
+				})
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+/* Function validateResource:
+ * returns every violation reported by the protoc-gen-validate rules,
+ * including those of the messages packed in Any fields, e.g., the HTTP
+ * connection manager of a listener or the filter of an extension config,
+ * which the validators of the resource do not look into.
+ */
+func validateResource(res types.Resource) []error {
+	return append(validationErrors(res), validateEmbedded(res.ProtoReflect(), "")...)
+}
+
+func validationErrors(m proto.Message) []error {
+	v, ok := m.(interface{ ValidateAll() error })
+	if !ok {
+		return nil
+	}
+	err := v.ValidateAll()
+	if err == nil {
+		return nil
+	}
+	if multi, ok := err.(interface{ AllErrors() []error }); ok {
+		return multi.AllErrors()
+	}
+	return []error{err}
+}
+
+/* Function validateEmbedded:
+ * walks the message fields of m looking for Any fields to validate.
+ */
+func validateEmbedded(m protoreflect.Message, path string) []error {
+	var errs []error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := fieldPath(path, fd)
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				break
+			}
+			v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				errs = append(errs, validateField(mv.Message(), fmt.Sprintf("%s[%s]", name, k.String()))...)
+				return true
+			})
+		case fd.IsList():
+			if fd.Message() == nil {
+				break
+			}
+			for i := 0; i < v.List().Len(); i++ {
+				errs = append(errs, validateField(v.List().Get(i).Message(), fmt.Sprintf("%s[%d]", name, i))...)
+			}
+		case fd.Message() != nil:
+			errs = append(errs, validateField(v.Message(), name)...)
+		}
+		return true
+	})
+	return errs
+}
+
+/* Function validateField:
+ * validates the payload of an Any, reporting its violations under path,
+ * and keeps walking any other message. Payloads of a type unknown to the
+ * control plane are left to Envoy.
+ */
+func validateField(m protoreflect.Message, path string) []error {
+	a, ok := m.Interface().(*anypb.Any)
+	if !ok {
+		return validateEmbedded(m, path)
+	}
+	payload, err := a.UnmarshalNew()
+	if err != nil {
+		return nil
+	}
+
+	var errs []error
+	for _, err := range append(validationErrors(payload), validateEmbedded(payload.ProtoReflect(), "")...) {
+		errs = append(errs, embeddedError{field: path, cause: err})
+	}
+	return errs
+}
+
+/* Structure embeddedError:
+ * a violation found in the payload of the Any field at field, shaped
+ * like the errors of the generated validators so splitValidationError
+ * can follow it.
+ */
+type embeddedError struct {
+	field string
+	cause error
+}
+
+func (e embeddedError) Field() string  { return e.field }
+func (e embeddedError) Reason() string { return e.cause.Error() }
+func (e embeddedError) Cause() error   { return e.cause }
+func (e embeddedError) Error() string  { return e.field + ": " + e.cause.Error() }
+
+/* Function fieldPath:
+ * appends a field to a dotted path, named as by the generated validators.
+ */
+func fieldPath(path string, fd protoreflect.FieldDescriptor) string {
+	name := fd.JSONName()
+	name = strings.ToUpper(name[:1]) + name[1:]
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+/* Function splitValidationError:
+ * follows the chain of nested validation errors to build the dotted
+ * field path and the innermost reason.
+ */
+func splitValidationError(err error) (string, string) {
+	var path []string
+	for {
+		fe, ok := err.(interface {
+			Field() string
+			Reason() string
+			Cause() error
+		})
+		if !ok {
+			return strings.Join(path, "."), err.Error()
+		}
+		path = append(path, fe.Field())
+		if fe.Cause() == nil {
+			return strings.Join(path, "."), fe.Reason()
+		}
+		if multi, ok := fe.Cause().(interface{ AllErrors() []error }); ok && len(multi.AllErrors()) > 0 {
+			err = multi.AllErrors()[0]
+		} else {
+			err = fe.Cause()
+		}
+	}
+}
+
+/* Function blameLabel:
+ * maps an invalid field of a generated resource to the service label
+ * the value was taken from.
+ */
+func blameLabel(typeURL, field string) string {
+	f := strings.ToLower(field)
+	switch typeURL {
+	case resource.ClusterType:
+		switch {
+		case strings.Contains(f, "portvalue"):
+			return "envoy.endpoint.port"
+		case strings.Contains(f, "address"):
+			return "envoy.route.upstream-host"
+		}
+	case resource.ListenerType:
+		if strings.Contains(f, "address") {
+			return "envoy.listener.port"
+		}
+	case resource.RouteType:
+		switch {
+		case strings.Contains(f, "timeout"):
+			return "envoy.endpoint.timeout"
+		case strings.Contains(f, "match"):
+			return "envoy.route.path"
+		case strings.Contains(f, "rewrite"):
+			return "envoy.route.upstream-host"
+		case strings.Contains(f, "requestheaderstoremove"):
+			return "envoy.route.request-headers-remove"
+		case strings.Contains(f, "requestheaderstoadd"):
+			return "envoy.route.request-headers-add"
+		case strings.Contains(f, "responseheaderstoremove"):
+			return "envoy.route.response-headers-remove"
+		case strings.Contains(f, "responseheaderstoadd"):
+			return "envoy.route.response-headers-add"
+		}
+	case resource.RuntimeType:
+		return "envoy.runtime"
+	case resource.ExtensionConfigType:
+		switch {
+		case strings.Contains(f, "delay"):
+			return "envoy.fault.delay"
+		case strings.Contains(f, "abort"):
+			return "envoy.fault.abort-status"
+		}
+	}
+	return "envoy.status.node-id" // resource names are derived from the node ID
+}
+
+func routeClusterReferences(rc *route.RouteConfiguration) []string {
+	var refs []string
+	for _, vh := range rc.GetVirtualHosts() {
+		for _, r := range vh.GetRoutes() {
+			action := r.GetRoute()
+			if action == nil {
+				continue
+			}
+			if c := action.GetCluster(); c != "" {
+				refs = append(refs, c)
+			}
+			for _, wc := range action.GetWeightedClusters().GetClusters() {
+				refs = append(refs, wc.GetName())
+			}
+		}
+	}
+	return refs
+}
+
+func listenerRouteReferences(l *listener.Listener) ([]string, error) {
+	var refs []string
+	for _, chain := range l.GetFilterChains() {
+		for _, filter := range chain.GetFilters() {
+			if filter.GetName() != wellknown.HTTPConnectionManager || filter.GetTypedConfig() == nil {
+				continue
+			}
+			manager := &hcm.HttpConnectionManager{}
+			if err := filter.GetTypedConfig().UnmarshalTo(manager); err != nil {
+				return refs, fmt.Errorf("unreadable HTTP connection manager: %w", err)
+			}
+			if name := manager.GetRds().GetRouteConfigName(); name != "" {
+				refs = append(refs, name)
+			}
+		}
+	}
+	return refs, nil
+}
+
+/* Function listenerExtensionReferences:
+ * returns the names of the HTTP filters a listener fetches over ECDS.
+ */
+func listenerExtensionReferences(l *listener.Listener) ([]string, error) {
+	var refs []string
+	for _, chain := range l.GetFilterChains() {
+		for _, filter := range chain.GetFilters() {
+			if filter.GetName() != wellknown.HTTPConnectionManager || filter.GetTypedConfig() == nil {
+				continue
+			}
+			manager := &hcm.HttpConnectionManager{}
+			if err := filter.GetTypedConfig().UnmarshalTo(manager); err != nil {
+				return refs, fmt.Errorf("unreadable HTTP connection manager: %w", err)
+			}
+			for _, f := range manager.GetHttpFilters() {
+				if f.GetConfigDiscovery() != nil {
+					refs = append(refs, f.GetName())
+				}
+			}
+		}
+	}
+	return refs, nil
+}
+
+func shortTypeName(typeURL string) string {
+	return strings.TrimPrefix(typeURL, resource.APITypePrefix)
+}
@@ -0,0 +1,81 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"sort"
+
+	"envoy-swarm-control/pkg/xdstypes"
+
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+const versionLength = 16 // hex characters kept from the SHA-256 digest
+
+/* Function newSnapshot:
+ * builds a snapshot in which every resource type carries its own version,
+ * derived from the content of its resources. Identical configurations map
+ * to identical versions, so Envoy is only sent the types that changed.
+ */
+func newSnapshot(resources map[string][]types.Resource) (*cache.Snapshot, error) {
+	snap := &cache.Snapshot{}
+	for typ, items := range resources {
+		index := cache.GetResponseType(typ)
+		if index == types.UnknownType {
+			return nil, errors.New("unknown resource type: " + typ)
+		}
+
+		version, err := resourceVersion(items)
+		if err != nil {
+			return nil, err
+		}
+		snap.Resources[index] = cache.NewResources(version, items)
+	}
+	return snap, nil
+}
+
+/* Function resourceVersion:
+ * returns a deterministic hash of the serialized resources, independent
+ * of the order in which they are given. Names and payloads are length
+ * prefixed, so that no two sets of resources hash the same input.
+ */
+func resourceVersion(items []types.Resource) (string, error) {
+	sorted := make([]types.Resource, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return cache.GetResourceName(sorted[i]) < cache.GetResourceName(sorted[j])
+	})
+
+	h := sha256.New()
+	for _, item := range sorted {
+		b, err := cache.MarshalResource(item)
+		if err != nil {
+			return "", err
+		}
+		writeField(h, []byte(cache.GetResourceName(item)))
+		writeField(h, b)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:versionLength], nil
+}
+
+func writeField(h hash.Hash, b []byte) {
+	binary.Write(h, binary.BigEndian, uint64(len(b)))
+	h.Write(b)
+}
+
+/* Function snapshotVersions:
+ * returns the version of every non-empty resource type, for logging.
+ */
+func snapshotVersions(snap cache.ResourceSnapshot) map[string]string {
+	versions := make(map[string]string)
+	for _, typ := range xdstypes.ResourceTypes {
+		if v := snap.GetVersion(typ); v != "" {
+			versions[typ] = v
+		}
+	}
+	return versions
+}
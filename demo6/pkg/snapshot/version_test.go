@@ -0,0 +1,43 @@
+package snapshot
+
+import (
+	"testing"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+)
+
+func TestResourceVersion(t *testing.T) {
+	t.Parallel()
+
+	a := &cluster.Cluster{Name: "a"}
+	b := &cluster.Cluster{Name: "b"}
+	base, err := resourceVersion([]types.Resource{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		items []types.Resource
+		same  bool
+	}{
+		{"reordered", []types.Resource{b, a}, true},
+		{"modified", []types.Resource{a, &cluster.Cluster{Name: "b", AltStatName: "b"}}, false},
+		{"removed", []types.Resource{a}, false},
+		{"renamed", []types.Resource{a, &cluster.Cluster{Name: "c"}}, false},
+	}
+
+	for _, test := range tests {
+		version, err := resourceVersion(test.items)
+		if err != nil {
+			t.Fatalf("%s: resourceVersion() error: %v", test.name, err)
+		}
+		if len(version) != versionLength {
+			t.Errorf("%s: resourceVersion() = %q, want %d characters", test.name, version, versionLength)
+		}
+		if (version == base) != test.same {
+			t.Errorf("%s: resourceVersion() = %q against %q, same: %v", test.name, version, base, test.same)
+		}
+	}
+}
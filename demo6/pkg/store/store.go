@@ -0,0 +1,214 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"envoy-swarm-control/pkg/xdstypes"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+const (
+	servicesDir  = "services"
+	snapshotsDir = "snapshots"
+	fileMode     = os.FileMode(0o600)
+	dirMode      = os.FileMode(0o700)
+)
+
+/* Interface Store:
+ * persists the desired state (one opaque JSON document per service) and
+ * the last good snapshot of every node.
+ */
+type Store interface {
+	SaveService(name string, data []byte) error
+	LoadServices() (map[string][]byte, error)
+	SaveSnapshot(nodeID string, snap cache.ResourceSnapshot) error
+	LoadSnapshots() (map[string]*cache.Snapshot, error)
+}
+
+/* Structure FileStore:
+ * a Store backed by a directory of JSON files, snapshots being written
+ * as protojson so they stay human readable. Snapshots include the SDS
+ * secrets served to the nodes, private keys included, hence the files
+ * being only readable by the owner.
+ */
+type FileStore struct {
+	dir string
+}
+
+var _ Store = &FileStore{}
+
+type persistedType struct {
+	Version   string            `json:"version"`
+	Resources []json.RawMessage `json:"resources"` // protojson of google.protobuf.Any
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	for _, sub := range []string{servicesDir, snapshotsDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), dirMode); err != nil {
+			return nil, err
+		}
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) SaveService(name string, data []byte) error {
+	return writeFileAtomic(s.path(servicesDir, name), data)
+}
+
+func (s *FileStore) LoadServices() (map[string][]byte, error) {
+	out := make(map[string][]byte)
+	err := s.each(servicesDir, func(name string, data []byte) error {
+		out[name] = data
+		return nil
+	})
+	return out, err
+}
+
+func (s *FileStore) SaveSnapshot(nodeID string, snap cache.ResourceSnapshot) error {
+	doc := make(map[string]persistedType)
+	for _, typ := range xdstypes.ResourceTypes {
+		items := snap.GetResources(typ)
+		if len(items) == 0 {
+			continue
+		}
+
+		pt := persistedType{Version: snap.GetVersion(typ)}
+		for name, res := range items {
+			a, err := anypb.New(res)
+			if err != nil {
+				return fmt.Errorf("persisting %s %s: %w", typ, name, err)
+			}
+			b, err := protojson.Marshal(a)
+			if err != nil {
+				return fmt.Errorf("persisting %s %s: %w", typ, name, err)
+			}
+			pt.Resources = append(pt.Resources, b)
+		}
+		doc[typ] = pt
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(snapshotsDir, nodeID), data)
+}
+
+/* Function LoadSnapshots:
+ * returns the persisted snapshot of every node. A snapshot that can't be
+ * read is logged and skipped, so one bad file does not hold back the
+ * snapshots of the other nodes.
+ */
+func (s *FileStore) LoadSnapshots() (map[string]*cache.Snapshot, error) {
+	out := make(map[string]*cache.Snapshot)
+	err := s.each(snapshotsDir, func(nodeID string, data []byte) error {
+		snap, err := parseSnapshot(data)
+		if err != nil {
+			logrus.Warnf("Skipping unreadable persisted snapshot of node %s: %v", nodeID, err)
+			return nil
+		}
+		out[nodeID] = snap
+		return nil
+	})
+	return out, err
+}
+
+func parseSnapshot(data []byte) (*cache.Snapshot, error) {
+	var doc map[string]persistedType
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	snap := &cache.Snapshot{}
+	for typ, pt := range doc {
+		index := cache.GetResponseType(typ)
+		if index == types.UnknownType {
+			return nil, fmt.Errorf("unknown resource type %s", typ)
+		}
+
+		items := make([]types.Resource, 0, len(pt.Resources))
+		for _, raw := range pt.Resources {
+			a := &anypb.Any{}
+			if err := protojson.Unmarshal(raw, a); err != nil {
+				return nil, err
+			}
+			msg, err := a.UnmarshalNew()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, msg)
+		}
+		snap.Resources[index] = cache.NewResources(pt.Version, items)
+	}
+	return snap, nil
+}
+
+/* Function each:
+ * calls fn with the unescaped name and content of every file in sub.
+ */
+func (s *FileStore) each(sub string, fn func(name string, data []byte) error) error {
+	entries, err := os.ReadDir(filepath.Join(s.dir, sub))
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		name, err := url.PathUnescape(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, sub, e.Name()))
+		if err != nil {
+			return err
+		}
+		if err := fn(name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) path(sub, name string) string {
+	return filepath.Join(s.dir, sub, url.PathEscape(name)+".json")
+}
+
+/* Function writeFileAtomic:
+ * writes to a temporary file first so a crash never leaves a truncated file.
+ */
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), fileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
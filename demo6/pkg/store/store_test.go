@@ -0,0 +1,112 @@
+package store_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"envoy-swarm-control/pkg/store"
+
+	"google.golang.org/protobuf/proto"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+func TestFileStore_Services(t *testing.T) {
+	t.Parallel()
+
+	s, err := store.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services := map[string]string{
+		"envoy-1":       `{"ServiceName":"envoy-1"}`,
+		"stack/envoy-2": `{"ServiceName":"envoy-2"}`, // escaped into a single file name
+	}
+	for name, data := range services {
+		if err := s.SaveService(name, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, err := s.LoadServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(services) {
+		t.Fatalf("LoadServices() returned %d services, want %d", len(loaded), len(services))
+	}
+	for name, data := range services {
+		if string(loaded[name]) != data {
+			t.Errorf("LoadServices()[%s] = %s, want %s", name, loaded[name], data)
+		}
+	}
+}
+
+func TestFileStore_Snapshots(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	s, err := store.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := cache.NewSnapshot("", map[string][]types.Resource{
+		resource.ClusterType:  {&cluster.Cluster{Name: "local_node_1_cluster"}, &cluster.Cluster{Name: "local_node_1_tracing"}},
+		resource.ListenerType: {&listener.Listener{Name: "local_node_1_listener"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved.Resources[types.Cluster].Version = "clusters-v1"
+	saved.Resources[types.Listener].Version = "listeners-v1"
+	if err := s.SaveSnapshot("local_node_1", saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "snapshots", "local_node_2.json"), []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := s.LoadSnapshots()
+	if err != nil {
+		t.Fatalf("LoadSnapshots() error: %v, an unreadable snapshot should be skipped", err)
+	}
+	if _, ok := loaded["local_node_2"]; ok {
+		t.Error("LoadSnapshots() returned the unreadable snapshot of local_node_2")
+	}
+	restored, ok := loaded["local_node_1"]
+	if !ok {
+		t.Fatal("LoadSnapshots() did not return the snapshot of local_node_1")
+	}
+
+	for _, typ := range []string{resource.ClusterType, resource.ListenerType, resource.RouteType} {
+		if got, want := restored.GetVersion(typ), saved.GetVersion(typ); got != want {
+			t.Errorf("version of %s = %q, want %q", typ, got, want)
+		}
+		want := saved.GetResources(typ)
+		got := restored.GetResources(typ)
+		if len(got) != len(want) {
+			t.Errorf("%s has %d resources, want %d", typ, len(got), len(want))
+			continue
+		}
+		for name, res := range want {
+			if !proto.Equal(got[name], res) {
+				t.Errorf("%s %s = %v, want %v", typ, name, got[name], res)
+			}
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "snapshots", "local_node_1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("snapshot file mode = %o, want 600 as it holds secrets", mode)
+	}
+}
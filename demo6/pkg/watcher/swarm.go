@@ -19,8 +19,11 @@ import (
 /* Function InitUpdateChannel:
  * sets the update channel to an empty structure.
  */
-func InitUpdateChannel(updateChannel chan snapshot.ServiceLabels) {
-	updateChannel <- snapshot.ServiceLabels{}
+func InitUpdateChannel(ctx context.Context, updateChannel chan snapshot.ServiceLabels) {
+	select {
+	case updateChannel <- snapshot.ServiceLabels{}:
+	case <-ctx.Done():
+	}
 }
 
 func StartWatcher(ctx context.Context, cli docker.APIClient, ingressNetwork string, updateChannel chan snapshot.ServiceLabels) {
@@ -34,9 +37,16 @@ func StartWatcher(ctx context.Context, cli docker.APIClient, ingressNetwork stri
 	 */
 	for {
 		select {
+		case <-ctx.Done():
+			return
+
 		case err := <-errorEvent:
+			if ctx.Err() != nil { // stopped on purpose, e.g., leadership lost
+				return
+			}
 			logrus.Errorf(err.Error())
 			StartWatcher(ctx, cli, ingressNetwork, updateChannel)
+			return
 
 		case event := <-events:
 			logrus.WithFields(logrus.Fields{"type": event.Type, "action": event.Action}).Debugf("Docker swarm service event received")
@@ -57,7 +67,7 @@ func StartWatcher(ctx context.Context, cli docker.APIClient, ingressNetwork stri
 
 			args := filters.NewArgs()
 			args.Add("name", serviceName)
-			services, err := cli.ServiceList(context.Background(), types.ServiceListOptions{Filters: args})
+			services, err := cli.ServiceList(ctx, types.ServiceListOptions{Filters: args})
 			if err != nil {
 				return
 			}
@@ -79,8 +89,14 @@ func StartWatcher(ctx context.Context, cli docker.APIClient, ingressNetwork stri
 					logrus.Debugf("Skipping service because labels are invalid: %s", err.Error())
 					return
 				}
+				labels.ServiceName = service.Spec.Name
+				labels.Event = fmt.Sprintf("%s %s", event.Type, event.Action)
 
-				updateChannel <- *labels
+				select {
+				case updateChannel <- *labels:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}
 	}
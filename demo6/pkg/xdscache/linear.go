@@ -0,0 +1,289 @@
+package xdscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"envoy-swarm-control/pkg/xdstypes"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+/* Structure LinearSnapshotCache:
+ * a SnapshotCache whose resources live in one linear cache per node and
+ * type URL. Every resource carries its own version, so setting a snapshot
+ * only pushes the resources that actually changed, which is what Delta
+ * xDS clients are sent. State-of-the-world clients keep working.
+ *
+ * Snapshots report the versions they were set with, i.e., content hashes
+ * as with the snapshot cache; ContentVersion maps the versions of the
+ * linear caches, which Envoy acknowledges, back to them.
+ *
+ * The caches of a node are created by its first snapshot or watch, and
+ * dropped when its snapshot is cleared, or when the last watch of a node
+ * without a snapshot is cancelled.
+ */
+type LinearSnapshotCache struct {
+	mu        sync.Mutex
+	nodes     map[string]*nodeCache
+	snapshots map[string]cache.ResourceSnapshot
+}
+
+// Versions of a linear cache remembered per node and type URL, enough for ACKs in flight
+const versionHistory = 16
+
+type nodeCache struct {
+	mux    *cache.MuxCache
+	linear map[string]*cache.LinearCache // type URL -> resources of the node
+
+	mu       sync.Mutex                  // held across diffing and updating the linear caches, taken before c.mu
+	versions map[string]uint64           // type URL -> version of the linear cache
+	history  map[string][]versionMapping // type URL -> last versions of the linear cache
+}
+
+type versionMapping struct {
+	linear  string
+	content string
+}
+
+var _ cache.SnapshotCache = &LinearSnapshotCache{}
+
+func NewLinearSnapshotCache() *LinearSnapshotCache {
+	return &LinearSnapshotCache{
+		nodes:     make(map[string]*nodeCache),
+		snapshots: make(map[string]cache.ResourceSnapshot),
+	}
+}
+
+/* Function SetSnapshot:
+ * diffs the snapshot against the resources held for the node and only
+ * updates or deletes the resources that differ. A failure to notify the
+ * watches of one type still leaves the resources updated, so the other
+ * types are updated and the snapshot recorded before it is returned.
+ */
+func (c *LinearSnapshotCache) SetSnapshot(_ context.Context, node string, snap cache.ResourceSnapshot) error {
+	n := c.lockNode(node)
+	defer n.mu.Unlock()
+
+	var errs []error
+	for _, typ := range xdstypes.ResourceTypes {
+		linear := n.linear[typ]
+		desired := snap.GetResources(typ)
+		current := linear.GetResources()
+
+		toUpdate := make(map[string]types.Resource)
+		for name, res := range desired {
+			if old, ok := current[name]; !ok || !proto.Equal(old, res) {
+				toUpdate[name] = res
+			}
+		}
+		var toDelete []string
+		for name := range current {
+			if _, ok := desired[name]; !ok {
+				toDelete = append(toDelete, name)
+			}
+		}
+
+		if len(toUpdate) == 0 && len(toDelete) == 0 {
+			continue
+		}
+		logrus.Debugf("Node %s %s: %d updated, %d removed", node, typ, len(toUpdate), len(toDelete))
+		// The linear cache bumps its version once per call, even when notifying its watches fails
+		n.bump(typ, snap.GetVersion(typ))
+		if err := linear.UpdateResources(toUpdate, toDelete); err != nil {
+			errs = append(errs, fmt.Errorf("updating %s of node %s: %w", typ, node, err))
+		}
+	}
+
+	c.mu.Lock()
+	c.snapshots[node] = snap
+	c.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+/* Function ContentVersion:
+ * returns the version a snapshot was set with for the given version of a
+ * node's linear cache, empty if it is unknown or too old.
+ */
+func (c *LinearSnapshotCache) ContentVersion(node, typeURL, version string) string {
+	c.mu.Lock()
+	n, ok := c.nodes[node]
+	c.mu.Unlock()
+	if !ok {
+		return ""
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, v := range n.history[typeURL] {
+		if v.linear == version {
+			return v.content
+		}
+	}
+	return ""
+}
+
+/* Function bump:
+ * mirrors the version increment of a linear cache. Must hold n.mu.
+ */
+func (n *nodeCache) bump(typeURL, content string) {
+	n.versions[typeURL]++
+	history := append(n.history[typeURL], versionMapping{
+		linear:  strconv.FormatUint(n.versions[typeURL], 10),
+		content: content,
+	})
+	if len(history) > versionHistory {
+		history = history[len(history)-versionHistory:]
+	}
+	n.history[typeURL] = history
+}
+
+func (c *LinearSnapshotCache) GetSnapshot(node string) (cache.ResourceSnapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snap, ok := c.snapshots[node]
+	if !ok {
+		return nil, fmt.Errorf("no snapshot found for node %s", node)
+	}
+	return snap, nil
+}
+
+/* Function ClearSnapshot:
+ * drops the caches of a node. Watches still open on them are told that
+ * every resource was removed.
+ */
+func (c *LinearSnapshotCache) ClearSnapshot(node string) {
+	c.mu.Lock()
+	n, ok := c.nodes[node]
+	delete(c.nodes, node)
+	delete(c.snapshots, node)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, linear := range n.linear {
+		linear.SetResources(nil)
+	}
+}
+
+// Status info is only kept by the snapshot cache
+func (c *LinearSnapshotCache) GetStatusInfo(string) cache.StatusInfo {
+	return nil
+}
+
+func (c *LinearSnapshotCache) GetStatusKeys() []string {
+	return nil
+}
+
+func (c *LinearSnapshotCache) CreateWatch(req *cache.Request, sub cache.Subscription, value chan cache.Response) (func(), error) {
+	nodeID := req.GetNode().GetId()
+	n := c.node(nodeID)
+	cancel, err := n.mux.CreateWatch(req, sub, value)
+	return c.pruneOnCancel(nodeID, n, cancel, err)
+}
+
+func (c *LinearSnapshotCache) CreateDeltaWatch(req *cache.DeltaRequest, sub cache.Subscription, value chan cache.DeltaResponse) (func(), error) {
+	nodeID := req.GetNode().GetId()
+	n := c.node(nodeID)
+	cancel, err := n.mux.CreateDeltaWatch(req, sub, value)
+	return c.pruneOnCancel(nodeID, n, cancel, err)
+}
+
+/* Function pruneOnCancel:
+ * wraps the cancel function of a watch so that the caches of a node no
+ * snapshot was set for are dropped with its last watch.
+ */
+func (c *LinearSnapshotCache) pruneOnCancel(nodeID string, n *nodeCache, cancel func(), err error) (func(), error) {
+	if err != nil {
+		c.prune(nodeID, n)
+		return nil, err
+	}
+	return func() {
+		if cancel != nil {
+			cancel()
+		}
+		c.prune(nodeID, n)
+	}, nil
+}
+
+func (c *LinearSnapshotCache) prune(nodeID string, n *nodeCache) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.snapshots[nodeID]; ok || c.nodes[nodeID] != n {
+		return
+	}
+	for _, linear := range n.linear {
+		if linear.NumCacheWatches() > 0 {
+			return
+		}
+	}
+	delete(c.nodes, nodeID)
+}
+
+/* Function lockNode:
+ * returns the caches of a node with n.mu held, retrying if they were
+ * dropped before the lock was taken.
+ */
+func (c *LinearSnapshotCache) lockNode(nodeID string) *nodeCache {
+	for {
+		n := c.node(nodeID)
+		n.mu.Lock()
+		c.mu.Lock()
+		current := c.nodes[nodeID] == n
+		c.mu.Unlock()
+		if current {
+			return n
+		}
+		n.mu.Unlock()
+	}
+}
+
+func (c *LinearSnapshotCache) Fetch(context.Context, *cache.Request) (cache.Response, error) {
+	return nil, errors.New("fetch is not supported by the linear cache")
+}
+
+/* Function node:
+ * returns the caches of a node, creating them on first use so that a
+ * watch can be opened before the node's first snapshot is set.
+ */
+func (c *LinearSnapshotCache) node(nodeID string) *nodeCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.nodes[nodeID]
+	if ok {
+		return n
+	}
+
+	n = &nodeCache{
+		mux: &cache.MuxCache{
+			Classify:      func(req *cache.Request) string { return req.GetTypeUrl() },
+			ClassifyDelta: func(req *cache.DeltaRequest) string { return req.GetTypeUrl() },
+			Caches:        make(map[string]cache.Cache, len(xdstypes.ResourceTypes)),
+		},
+		linear:   make(map[string]*cache.LinearCache, len(xdstypes.ResourceTypes)),
+		versions: make(map[string]uint64, len(xdstypes.ResourceTypes)),
+		history:  make(map[string][]versionMapping, len(xdstypes.ResourceTypes)),
+	}
+	for _, typ := range xdstypes.ResourceTypes {
+		linear := cache.NewLinearCache(typ)
+		n.linear[typ] = linear
+		n.mux.Caches[typ] = linear
+	}
+	c.nodes[nodeID] = n
+	return n
+}
@@ -0,0 +1,71 @@
+package xdscache
+
+import (
+	"context"
+	"testing"
+
+	"envoy-swarm-control/pkg/xdstypes"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+)
+
+func (c *LinearSnapshotCache) known(nodeID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.nodes[nodeID]
+	return ok
+}
+
+func TestLinearSnapshotCache_Nodes(t *testing.T) {
+	t.Parallel()
+
+	c := NewLinearSnapshotCache()
+	snap, err := cache.NewSnapshot("clusters-v1", map[string][]types.Resource{
+		resource.ClusterType: {&cluster.Cluster{Name: "local_node_1_cluster"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetSnapshot(context.Background(), "local_node_1", snap); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := c.ContentVersion("local_node_1", resource.ClusterType, "1"); v != "clusters-v1" {
+		t.Errorf("ContentVersion() = %q, want %q", v, "clusters-v1")
+	}
+	if v := c.ContentVersion("local_node_2", resource.ClusterType, "1"); v != "" || c.known("local_node_2") {
+		t.Errorf("ContentVersion() of an unknown node = %q, known: %v, want nothing kept", v, c.known("local_node_2"))
+	}
+
+	// A watch of a node without a snapshot only keeps its caches while open
+	req := &cache.Request{Node: &core.Node{Id: "local_node_2"}, TypeUrl: resource.ClusterType, VersionInfo: "0"}
+	cancel, err := c.CreateWatch(req, stream.NewSotwSubscription(nil, true), make(chan cache.Response, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.known("local_node_2") {
+		t.Error("the caches of a watched node were not created")
+	}
+	cancel()
+	if c.known("local_node_2") {
+		t.Error("the caches of a node without a snapshot were kept after its last watch")
+	}
+
+	c.ClearSnapshot("local_node_1")
+	if c.known("local_node_1") {
+		t.Error("the caches of a cleared node were kept")
+	}
+	if _, err := c.GetSnapshot("local_node_1"); err == nil {
+		t.Error("GetSnapshot() of a cleared node succeeded")
+	}
+	for _, typ := range xdstypes.ResourceTypes {
+		if v := c.ContentVersion("local_node_1", typ, "1"); v != "" {
+			t.Errorf("ContentVersion() of a cleared node = %q", v)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package xdstypes
+
+import (
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+// Type URLs of every resource the control plane serves, in the order snapshots are built, persisted, diffed and shown
+var ResourceTypes = []string{
+	resource.ClusterType,
+	resource.RouteType,
+	resource.ListenerType,
+	resource.SecretType,
+	resource.RuntimeType,
+	resource.ExtensionConfigType,
+}